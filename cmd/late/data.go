@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * Data is the set of variables a template is rendered with.
+ * It's built up from any number of data files, which are deep merged together
+ * in the order given, and then from individual `--set` values.
+ */
+type Data map[string]interface{}
+
+// LoadFile reads a data file, using the file's extension to decide whether
+// it is JSON, YAML or TOML, and merges its contents into the data.
+func (d Data) LoadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var parsed map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &parsed)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &parsed)
+	case ".toml":
+		err = toml.Unmarshal(content, &parsed)
+	default:
+		return fmt.Errorf("%s: unknown data format, expected a .json, .yaml, .yml or .toml file", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	d.merge(normalize(parsed).(map[string]interface{}))
+	return nil
+}
+
+// Set applies a single `key=value` assignment.
+// Dotted keys set values in nested hashes, creating them as needed:
+//
+//	user.name=Jane
+//
+// Values that are valid JSON (numbers, true, false, null, arrays, hashes and
+// quoted strings) are used as that type. Anything else is used as a string.
+func (d Data) Set(assignment string) error {
+	eq := strings.Index(assignment, "=")
+	if eq < 1 {
+		return fmt.Errorf("invalid --set %q, expected key=value", assignment)
+	}
+
+	key := assignment[:eq]
+	raw := assignment[eq+1:]
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	parts := strings.Split(key, ".")
+	current := map[string]interface{}(d)
+
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[part] = next
+		}
+
+		current = next
+	}
+
+	current[parts[len(parts)-1]] = value
+	return nil
+}
+
+func (d Data) merge(from map[string]interface{}) {
+	mergeMaps(d, from)
+}
+
+func mergeMaps(into, from map[string]interface{}) {
+	for key, value := range from {
+		fromMap, fromIsMap := value.(map[string]interface{})
+		intoMap, intoIsMap := into[key].(map[string]interface{})
+
+		if fromIsMap && intoIsMap {
+			mergeMaps(intoMap, fromMap)
+		} else {
+			into[key] = value
+		}
+	}
+}
+
+// The YAML and TOML decoders hand back some types that Late doesn't know
+// how to deal with. Turn those into ones it does.
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, v := range value {
+			value[key] = normalize(v)
+		}
+
+		return value
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+
+		for key, v := range value {
+			converted[fmt.Sprint(key)] = normalize(v)
+		}
+
+		return converted
+	case []interface{}:
+		for i, v := range value {
+			value[i] = normalize(v)
+		}
+
+		return value
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(value))
+
+		for i, v := range value {
+			converted[i] = normalize(v)
+		}
+
+		return converted
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestData_Set(t *testing.T) {
+	tests := []struct {
+		sets     []string
+		expected string
+	}{
+		{[]string{"name=Jane"}, `{"name":"Jane"}`},
+		{[]string{"count=3", "on=true", "off=null"}, `{"count":3,"off":null,"on":true}`},
+		{[]string{"list=[1, 2]", `quoted="3"`}, `{"list":[1,2],"quoted":"3"}`},
+		{[]string{"user.name=Jane", "user.age=30"}, `{"user":{"age":30,"name":"Jane"}}`},
+		{[]string{"user=Jane", "user.name=Jane"}, `{"user":{"name":"Jane"}}`},
+		{[]string{"math=1+1=2"}, `{"math":"1+1=2"}`},
+	}
+
+	for i, test := range tests {
+		data := Data{}
+
+		for _, set := range test.sets {
+			if err := data.Set(set); err != nil {
+				t.Fatalf("(%d) Unexpected error: %s", i, err)
+			}
+		}
+
+		encoded, _ := json.Marshal(data)
+
+		if string(encoded) != test.expected {
+			t.Errorf("(%d) Wrong data. Expected %s got %s", i, test.expected, encoded)
+		}
+	}
+
+	for _, invalid := range []string{"name", "=value"} {
+		if err := (Data{}).Set(invalid); err == nil {
+			t.Errorf("Expected an error for --set %q", invalid)
+		}
+	}
+}
+
+func TestData_LoadFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"data.json": `{"site": {"name": "JSON", "tags": ["a", "b"]}, "json": true}`,
+		"data.yaml": "site:\n  name: YAML\n  nested:\n    1: one\nyaml: true\n",
+		"data.toml": "toml = true\nwhen = 2018-01-02T03:04:05Z\n[site]\nname = \"TOML\"\n[[site.pages]]\ntitle = \"Home\"\n",
+	}
+
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	data := Data{}
+
+	for _, name := range []string{"data.json", "data.yaml", "data.toml"} {
+		if err := data.LoadFile(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Unexpected error loading %s: %s", name, err)
+		}
+	}
+
+	encoded, _ := json.Marshal(data)
+	expected := `{"json":true,"site":{"name":"TOML","nested":{"1":"one"},"pages":[{"title":"Home"}],"tags":["a","b"]},"toml":true,"when":"2018-01-02T03:04:05Z","yaml":true}`
+
+	if string(encoded) != expected {
+		t.Errorf("Data files not merged correctly.\nExpected %s\ngot      %s", expected, encoded)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "data.xml"), []byte("<data/>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0644)
+
+	for _, name := range []string{"data.xml", "bad.json", "missing.json"} {
+		if err := data.LoadFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("Expected an error loading %s", name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/template"
+)
+
+/**
+ * The late command line tool renders a template to stdout or a file.
+ *
+ *   late [options] [template]
+ *
+ * The template is read from the given file, or from stdin when no file or "-"
+ * is given. Exits with 1 when the template has errors and 2 when late itself
+ * could not run, e.g. a missing data file.
+ */
+
+const usage = `Usage: late [options] [template]
+
+Render a Late template. The template is read from stdin if not given or "-".
+
+Options:
+`
+
+// Flags that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string       { return strings.Join(*s, ", ") }
+func (s *stringList) Set(val string) error { *s = append(*s, val); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var dataFiles, sets, includeDirs stringList
+
+	flags := flag.NewFlagSet("late", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&dataFiles, "data", "Load variables from a JSON, YAML or TOML `file`. Can be given more than once")
+	flags.Var(&sets, "set", "Set a variable as `key=value`. Can be given more than once")
+	flags.Var(&includeDirs, "I", "Look for included templates in `dir`. Can be given more than once.\nDefaults to the template's directory")
+	outputPath := flags.String("o", "", "Write output to `file` instead of stdout")
+	modeName := flags.String("mode", "strict", "How to handle template errors: strict, warn or lenient")
+
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	fail := func(format string, args ...interface{}) int {
+		fmt.Fprintf(stderr, "late: "+format+"\n", args...)
+		return 2
+	}
+
+	mode, ok := errorModes[*modeName]
+	if !ok {
+		return fail("unknown error mode %q", *modeName)
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	templatePath := flags.Arg(0)
+	templateName := templatePath
+	var body []byte
+	var err error
+
+	if templatePath == "" || templatePath == "-" {
+		templateName = "<stdin>"
+		body, err = ioutil.ReadAll(stdin)
+	} else {
+		body, err = ioutil.ReadFile(templatePath)
+	}
+
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	data := Data{}
+
+	for _, path := range dataFiles {
+		if err := data.LoadFile(path); err != nil {
+			return fail("%s", err)
+		}
+	}
+
+	for _, set := range sets {
+		if err := data.Set(set); err != nil {
+			return fail("%s", err)
+		}
+	}
+
+	if len(includeDirs) == 0 {
+		includeDirs = stringList{filepath.Dir(templatePath)}
+	}
+
+	var reader context.LayeredReader
+	for _, dir := range includeDirs {
+		reader = append(reader, &context.DirReader{Root: dir, Extension: ".late"})
+	}
+
+	ctx := context.New(context.Reader(reader), context.Mode(mode))
+	ctx.Assign(context.Assigns(data))
+
+	tpl := template.New(string(body), template.Name(templateName))
+
+	var renderErr error
+
+	if mode == context.Strict {
+		// Strict mode is all or nothing. Render everything first so a failed
+		// render doesn't leave partial output behind.
+		buffer := &bytes.Buffer{}
+		renderErr = tpl.RenderTo(buffer, ctx)
+
+		if renderErr == nil {
+			err = writeOutput(*outputPath, stdout, func(w io.Writer) error {
+				_, err := buffer.WriteTo(w)
+				return err
+			})
+		}
+	} else {
+		err = writeOutput(*outputPath, stdout, func(w io.Writer) error {
+			renderErr = tpl.RenderTo(w, ctx)
+			return nil
+		})
+	}
+
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	status := 0
+
+	if renderErr != nil {
+		switch renderErr := renderErr.(type) {
+		case *errors.Error:
+			printError(stderr, renderErr)
+		case errors.List:
+			for _, err := range renderErr {
+				printError(stderr, err)
+			}
+		default:
+			return fail("%s", renderErr)
+		}
+
+		status = 1
+	}
+
+	return status
+}
+
+// Give write a buffered writer to the output file, if one was asked for, or stdout
+func writeOutput(path string, stdout io.Writer, write func(io.Writer) error) error {
+	out := stdout
+
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	buffered := bufio.NewWriter(out)
+
+	if err := write(buffered); err != nil {
+		return err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if file, ok := out.(*os.File); ok && path != "" {
+		return file.Close()
+	}
+
+	return nil
+}
+
+var errorModes = map[string]context.ErrorMode{
+	"strict":  context.Strict,
+	"warn":    context.Warn,
+	"lenient": context.Lenient,
+}
+
+// Errors are printed in the usual compiler format, followed by the offending
+// line with the problem underlined:
+//
+//	page.late:3:14: error: Unknown filter 'nope'
+//	  {{ title | nope }}
+//	             ^^^^
+func printError(w io.Writer, err *errors.Error) {
+	name := err.Template
+	if name == "" {
+		name = "<template>"
+	}
+
+	fmt.Fprintf(w, "%s:%d:%d: %s: %s\n", name, err.Line, err.Column, err.Severity, err.Message)
+
+	if err.Snippet == "" || err.Column < 1 {
+		return
+	}
+
+	// Columns count characters, while the error's length is in bytes
+	line := []rune(err.Snippet)
+	column := err.Column - 1
+	if column > len(line) {
+		column = len(line)
+	}
+
+	// Errors can span several lines, only mark what's on the first
+	marked := string(line[column:])
+	if len(marked) > err.Length {
+		marked = marked[:err.Length]
+	}
+
+	length := utf8.RuneCountInString(marked)
+	if length < 1 {
+		length = 1
+	}
+
+	// Keep tabs in the padding so the markers line up with the snippet
+	padding := []rune{}
+	for _, r := range line[:column] {
+		if r == '\t' {
+			padding = append(padding, '\t')
+		} else {
+			padding = append(padding, ' ')
+		}
+	}
+
+	fmt.Fprintf(w, "  %s\n  %s%s\n", err.Snippet, string(padding), strings.Repeat("^", length))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"page.late":                `{{ title }}: {% include "partials/item" %}`,
+		"partials/item.late":       `<{{ item }}>`,
+		"other/partials/item.late": `[{{ item }}]`,
+		"data.json":                `{"title": "Page", "item": 1}`,
+		"broken.late":              "Before\n  {{ title | nope }}",
+		"missing_partial.late":     `{% include "nope" %}`,
+	}
+
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		stdout string
+		stderr string
+		status int
+	}{
+		{[]string{"-data", path("data.json"), path("page.late")}, "", "Page: <1>", "", 0},
+		{[]string{"-data", path("data.json"), "-set", "item=2", path("page.late")}, "", "Page: <2>", "", 0},
+		{[]string{"-set", "title=Other", "-set", "item=null", "-I", path("other"), "-I", dir, path("page.late")}, "", "Other: []", "", 0},
+		{[]string{"-set", "name=stdin"}, "Hi {{ name }}", "Hi stdin", "", 0},
+		{[]string{"-"}, "Hi", "Hi", "", 0},
+
+		// Strict mode outputs nothing when there are errors
+		{
+			[]string{"-data", path("data.json"), path("broken.late")}, "", "",
+			path("broken.late") + ":2:14: error: Unknown filter 'nope'\n    {{ title | nope }}\n               ^^^^\n",
+			1,
+		},
+		{[]string{"-mode", "warn"}, "A{{ 1 | nope }}B", "AB", "<stdin>:1:9: warning: Unknown filter 'nope'", 1},
+		{[]string{"-mode", "lenient"}, "A{{ 1 | nope }}B", "AB", "", 0},
+		{
+			[]string{"-mode", "warn"}, "Été: {{ 1 | nope }}", "Été: ",
+			"<stdin>:1:13: warning: Unknown filter 'nope'\n  Été: {{ 1 | nope }}\n              ^^^^\n",
+			1,
+		},
+		{[]string{}, "A{{ name }}B", "", "<stdin>:1:5: error: Undefined variable 'name'", 1},
+		{[]string{"-mode", "warn"}, "A{{ name }}B", "AB", "", 0},
+		{[]string{path("missing_partial.late")}, "", "", "error: Could not find partial 'nope'", 1},
+		{[]string{"-I", dir}, `{% include "../secret" %}`, "", "outside of the template directory", 1},
+
+		// Problems running late itself
+		{[]string{"-mode", "loud"}, "", "", "unknown error mode", 2},
+		{[]string{"-data", path("missing.json")}, "", "", "missing.json", 2},
+		{[]string{path("missing.late")}, "", "", "missing.late", 2},
+		{[]string{"-set", "nope"}, "", "", "invalid --set", 2},
+		{[]string{"one", "two"}, "", "", "Usage", 2},
+	}
+
+	for i, test := range tests {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+
+		status := run(test.args, strings.NewReader(test.stdin), stdout, stderr)
+
+		if status != test.status {
+			t.Errorf("(%d) Wrong exit status. Expected %d got %d. Stderr: %s", i, test.status, status, stderr)
+		}
+
+		if stdout.String() != test.stdout {
+			t.Errorf("(%d) Wrong output. Expected '%s' got '%s'", i, test.stdout, stdout)
+		}
+
+		if !strings.Contains(stderr.String(), test.stderr) || (test.stderr == "" && stderr.Len() > 0) {
+			t.Errorf("(%d) Wrong error output. Expected '%s' got '%s'", i, test.stderr, stderr)
+		}
+	}
+}
+
+func TestRun_OutputFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "out.txt")
+	status := run([]string{"-o", output, "-set", "x=1"}, strings.NewReader("x is {{ x }}"), &bytes.Buffer{}, &bytes.Buffer{})
+
+	if status != 0 {
+		t.Fatalf("Unexpected exit status %d", status)
+	}
+
+	if content, _ := ioutil.ReadFile(output); string(content) != "x is 1" {
+		t.Errorf("Wrong output written, got '%s'", content)
+	}
+
+	// A failed strict render does not touch the output file
+	output = filepath.Join(dir, "failed.txt")
+	run([]string{"-o", output}, strings.NewReader("{{ 1 | nope }}"), &bytes.Buffer{}, &bytes.Buffer{})
+
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Failed render still wrote the output file")
+	}
+}
@@ -1,30 +1,56 @@
 package context
 
 import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
 	s "github.com/jasonroelofs/late/template/statement"
 )
 
 type Assigns map[string]interface{}
 
+// ErrorMode controls how rendering reacts to errors in the template.
+type ErrorMode int
+
+const (
+	// Render as much as possible, returning all errors found as warnings.
+	Warn ErrorMode = iota
+
+	// Stop rendering at the very first error.
+	Strict
+
+	// Render as much as possible and don't report errors.
+	// Errors are still available through Context.Errors().
+	Lenient
+)
+
 /**
  * Evaluator is the set of functions that the Context needs to be
  * able to delegate down to evaluation when processing tags.
  */
 type Evaluator interface {
+	io.Writer
 	Eval(s.Statement) object.Object
 	EvalAll([]s.Statement) object.Object
+	EvalAllTo(io.Writer, []s.Statement)
 	Interrupt() string
 	ClearInterrupt()
 }
 
 type Context struct {
-	RenderFunc func(string, *Context) string
+	// RenderFunc is given the name of the partial to read and render,
+	// and where to write the partial's output
+	RenderFunc func(string, io.Writer, *Context) error
 
 	evaluator    Evaluator
 	globalScope  *Scope
 	currentScope *Scope
 	reader       FileReader
+	mode         ErrorMode
+	errors       []*errors.Error
 }
 
 func New(options ...func(*Context)) *Context {
@@ -46,6 +72,10 @@ func (c *Context) SetEvaluator(e Evaluator) {
 	c.evaluator = e
 }
 
+func (c *Context) Evaluator() Evaluator {
+	return c.evaluator
+}
+
 func (c *Context) Assign(assigns Assigns) {
 	for key, value := range assigns {
 		c.Set(key, value)
@@ -63,10 +93,16 @@ func (c *Context) ShadowSet(name string, value interface{}) {
 	c.currentScope.ShadowSet(name, object.New(value))
 }
 
+// Get returns object.NULL for variables that have never been set.
+// Use Lookup to tell these apart from variables set to null.
 func (c *Context) Get(name string) object.Object {
 	return c.currentScope.Get(name)
 }
 
+func (c *Context) Lookup(name string) (object.Object, bool) {
+	return c.currentScope.Lookup(name)
+}
+
 /**
  * Promoting a variable takes it out of the current scope
  * and moves it up to live at the global scope. This then ensures
@@ -94,26 +130,93 @@ func (c *Context) PopScope() {
 	c.currentScope = c.currentScope.Parent
 }
 
-func (c *Context) ReadFile(path string) string {
+func (c *Context) ReadFile(path string) (string, error) {
 	return c.reader.Read(path)
 }
 
-func (c *Context) Render(input string) object.Object {
+// StatFile describes the file at path, if the context's reader is a StatReader.
+// Otherwise the FileInfo is nil.
+func (c *Context) StatFile(path string) (*FileInfo, error) {
+	statReader, ok := c.reader.(StatReader)
+	if !ok {
+		return nil, nil
+	}
+
+	return statReader.Stat(path)
+}
+
+// ReaderID identifies the context's reader, so files read by name through
+// different readers can be told apart, e.g. when caching partials.
+// Readers that are pointers, maps or slices are identified by their address,
+// other readers by their type and value.
+func (c *Context) ReaderID() string {
+	value := reflect.ValueOf(c.reader)
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return fmt.Sprintf("%T@%x", c.reader, value.Pointer())
+	default:
+		return fmt.Sprintf("%T%+v", c.reader, c.reader)
+	}
+}
+
+// Render reads and renders the named partial in this context, writing
+// its output directly to the current output of the render.
+func (c *Context) Render(name string) error {
 	if c.RenderFunc == nil {
-		return object.NULL
+		return nil
+	}
+
+	return c.RenderFunc(name, c.evaluator, c)
+}
+
+// AddError records a problem found while rendering with this context.
+// Outside of Strict mode rendering carries on past the problem,
+// so it is recorded as a warning.
+func (c *Context) AddError(err *errors.Error) {
+	if c.mode != Strict {
+		// Parse errors are shared by every render of their template,
+		// so the warning is a copy rather than a change to the original.
+		warning := *err
+		warning.Severity = errors.SeverityWarning
+		err = &warning
 	}
 
-	return object.New(c.RenderFunc(input, c))
+	c.errors = append(c.errors, err)
+}
+
+func (c *Context) ErrorMode() ErrorMode {
+	return c.mode
+}
+
+// Halted is true when an error has been hit in Strict mode
+// and all further evaluation should stop.
+func (c *Context) Halted() bool {
+	return c.mode == Strict && len(c.errors) > 0
+}
+
+// Errors returns every problem found while rendering with this context,
+// including those found in included templates, in the order they happened.
+func (c *Context) Errors() []*errors.Error {
+	return c.errors
 }
 
 func (c *Context) Eval(s s.Statement) object.Object {
 	return c.evaluator.Eval(s)
 }
 
+// EvalAll evaluates the statements, writing their output directly
+// to the current output of the render.
 func (c *Context) EvalAll(stmts []s.Statement) object.Object {
 	return c.evaluator.EvalAll(stmts)
 }
 
+// EvalAllTo evaluates the statements, writing their output to w instead
+// of the current output of the render.
+func (c *Context) EvalAllTo(w io.Writer, stmts []s.Statement) {
+	c.evaluator.EvalAllTo(w, stmts)
+}
+
 func (c *Context) Interrupt() string {
 	return c.evaluator.Interrupt()
 }
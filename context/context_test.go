@@ -3,7 +3,9 @@ package context
 import (
 	"testing"
 
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
+	"github.com/jasonroelofs/late/template/token"
 )
 
 func TestGlobalAssigns(t *testing.T) {
@@ -39,6 +41,28 @@ func TestGlobalAssigns(t *testing.T) {
 	}
 }
 
+func TestLookup(t *testing.T) {
+	c := New()
+	c.Set("nothing", nil)
+
+	if value, defined := c.Lookup("nothing"); !defined || value != object.NULL {
+		t.Fatalf("Expected nothing to be defined as null, got %s, %t", value, defined)
+	}
+
+	c.PushScope()
+	c.Set("inner", 1)
+
+	if _, defined := c.Lookup("nothing"); !defined {
+		t.Fatalf("Did not find nothing in a parent scope")
+	}
+
+	c.PopScope()
+
+	if value, defined := c.Lookup("inner"); defined || value != object.NULL {
+		t.Fatalf("Expected inner to be undefined, got %s, %t", value, defined)
+	}
+}
+
 func TestScoping(t *testing.T) {
 	c := New()
 	c.Assign(Assigns{"global_key": "global_value"})
@@ -143,23 +167,67 @@ func TestPromote(t *testing.T) {
 func TestReadFile_NullReader(t *testing.T) {
 	c := New()
 
-	if c.ReadFile("file/path") != "ERROR: Reader not implemented. Cannot read content at file/path" {
-		t.Fatalf("Did not set up the Null Reader properly")
+	_, err := c.ReadFile("file/path")
+	if err == nil || err.Error() != "Reader not implemented. Cannot read content at file/path" {
+		t.Fatalf("Did not set up the Null Reader properly, got %v", err)
 	}
 }
 
 type TestReader struct{}
 
-func (t *TestReader) Read(path string) string {
-	return "I read from " + path
+func (t *TestReader) Read(path string) (string, error) {
+	return "I read from " + path, nil
 }
 
 func TestReadFile_CustomReader(t *testing.T) {
 	c := New(Reader(new(TestReader)))
 
-	file := c.ReadFile("file/path")
-	if file != "I read from file/path" {
-		t.Fatalf("Did not set up the Reader properly. Got `%s`", file)
+	file, err := c.ReadFile("file/path")
+	if err != nil || file != "I read from file/path" {
+		t.Fatalf("Did not set up the Reader properly. Got `%s`, %v", file, err)
+	}
+}
+
+func TestReaderID(t *testing.T) {
+	one := MapReader{"file": "One"}
+	two := MapReader{"file": "Two"}
+
+	if New(Reader(one)).ReaderID() != New(Reader(one)).ReaderID() {
+		t.Errorf("The same reader should always have the same ID")
+	}
+
+	if New(Reader(one)).ReaderID() == New(Reader(two)).ReaderID() {
+		t.Errorf("Different readers should have different IDs")
+	}
+
+	if New(Reader(&DirReader{Root: "."})).ReaderID() == New(Reader(&DirReader{Root: "."})).ReaderID() {
+		t.Errorf("Different pointer readers should have different IDs")
+	}
+}
+
+func TestAddError_Severity(t *testing.T) {
+	tests := []struct {
+		mode     ErrorMode
+		severity errors.Severity
+	}{
+		{Strict, errors.SeverityError},
+		{Warn, errors.SeverityWarning},
+		{Lenient, errors.SeverityWarning},
+	}
+
+	for i, test := range tests {
+		err := errors.New(errors.UnknownFilter, token.Token{Line: 1, Char: 1}, "Unknown filter 'nope'")
+
+		c := New(Mode(test.mode))
+		c.AddError(err)
+
+		if got := c.Errors()[0].Severity; got != test.severity {
+			t.Errorf("(%d) Wrong severity. Expected %s got %s", i, test.severity, got)
+		}
+
+		if err.Severity != errors.SeverityError {
+			t.Errorf("(%d) The original error was changed to %s", i, err.Severity)
+		}
 	}
 }
 
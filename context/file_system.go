@@ -1,17 +1,186 @@
 package context
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 type FileReader interface {
 	// Given a path, return the content of the file
 	// at that path. Use custom readers to define how
 	// to find other templates and partials (particularly with the
 	// `include` tag).
-	Read(string) string
+	// Readers should return a *NotFoundError if there is nothing at the path.
+	Read(string) (string, error)
+}
+
+// StatReader is implemented by FileReaders that can tell where a file is and
+// when it last changed without reading it. Partials read through a StatReader are
+// compiled once and only recompiled when the file changes.
+type StatReader interface {
+	// Stat returns a nil FileInfo if it can't tell where the file is.
+	Stat(string) (*FileInfo, error)
+}
+
+type FileInfo struct {
+	// Path must uniquely identify the file across all readers,
+	// e.g. its full path on disk.
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// NotFoundError is returned by FileReaders when there is no file at the
+// requested path.
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return "Could not find " + e.Path
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
 }
 
 // Provide a default null file system implementation
 // that always returns an error
 type NullReader struct{}
 
-func (n *NullReader) Read(path string) string {
-	return "ERROR: Reader not implemented. Cannot read content at " + path
+func (n *NullReader) Read(path string) (string, error) {
+	return "", fmt.Errorf("Reader not implemented. Cannot read content at %s", path)
+}
+
+/**
+ * DirReader reads files from a directory on disk.
+ * Paths are always relative to Root; paths that would reach outside of Root
+ * are refused.
+ *
+ * If Extension is set, it is added to any path that doesn't already end with it,
+ * so that `{% include "header" %}` can read `header.late`.
+ */
+type DirReader struct {
+	Root      string
+	Extension string
+}
+
+func (d *DirReader) Read(name string) (string, error) {
+	clean, err := cleanPath(name, d.Extension)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(d.Root, filepath.FromSlash(clean)))
+
+	if os.IsNotExist(err) {
+		return "", &NotFoundError{Path: name}
+	}
+
+	return string(content), err
+}
+
+func (d *DirReader) Stat(name string) (*FileInfo, error) {
+	clean, err := cleanPath(name, d.Extension)
+	if err != nil {
+		return nil, err
+	}
+
+	fullPath, err := filepath.Abs(filepath.Join(d.Root, filepath.FromSlash(clean)))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fullPath)
+
+	if os.IsNotExist(err) {
+		return nil, &NotFoundError{Path: name}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileInfo{Path: fullPath, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
+/**
+ * MapReader serves files from memory, keyed by path.
+ */
+type MapReader map[string]string
+
+func (m MapReader) Read(name string) (string, error) {
+	content, ok := m[name]
+	if !ok {
+		return "", &NotFoundError{Path: name}
+	}
+
+	return content, nil
+}
+
+/**
+ * LayeredReader searches each of its readers in order, returning the first
+ * file found. This allows for overrides, such as a theme whose templates
+ * replace those of a base theme:
+ *
+ *   LayeredReader{
+ *     &DirReader{Root: "themes/custom", Extension: ".late"},
+ *     &DirReader{Root: "themes/base", Extension: ".late"},
+ *   }
+ *
+ * Any error other than a NotFoundError stops the search.
+ */
+type LayeredReader []FileReader
+
+func (l LayeredReader) Read(name string) (string, error) {
+	for _, reader := range l {
+		content, err := reader.Read(name)
+
+		if !IsNotFound(err) {
+			return content, err
+		}
+	}
+
+	return "", &NotFoundError{Path: name}
+}
+
+// Stat finds the first layer with the file. If that can't be known because
+// a layer isn't a StatReader, no FileInfo is returned.
+func (l LayeredReader) Stat(name string) (*FileInfo, error) {
+	for _, reader := range l {
+		statReader, ok := reader.(StatReader)
+		if !ok {
+			return nil, nil
+		}
+
+		info, err := statReader.Stat(name)
+
+		if !IsNotFound(err) {
+			return info, err
+		}
+	}
+
+	return nil, &NotFoundError{Path: name}
+}
+
+// Turn a template name into a clean, slash separated path relative to
+// the reader's root, refusing anything that would escape that root.
+func cleanPath(name, extension string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Cannot read %s: path is outside of the template directory", name)
+	}
+
+	if extension != "" && !strings.HasSuffix(clean, extension) {
+		clean += extension
+	}
+
+	return clean, nil
 }
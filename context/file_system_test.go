@@ -0,0 +1,154 @@
+package context
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirReader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "root", "partials"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "root", "page.late"), []byte("page"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "root", "partials", "header.late"), []byte("header"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "root", "notes.txt"), []byte("notes"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.late"), []byte("secret"), 0644)
+
+	tests := []struct {
+		extension string
+		path      string
+		expected  string
+		notFound  bool
+		invalid   bool
+	}{
+		{"", "page.late", "page", false, false},
+		{"", "notes.txt", "notes", false, false},
+		{".late", "page", "page", false, false},
+		{".late", "page.late", "page", false, false},
+		{".late", "partials/header", "header", false, false},
+		{".late", "partials/../page", "page", false, false},
+		{".late", "missing", "", true, false},
+		{"", "page", "", true, false},
+
+		// No reaching outside of the root
+		{".late", "../secret", "", false, true},
+		{".late", "partials/../../secret", "", false, true},
+		{".late", "/etc/passwd", "", false, true},
+		{"", "..", "", false, true},
+	}
+
+	for i, test := range tests {
+		reader := &DirReader{Root: filepath.Join(dir, "root"), Extension: test.extension}
+		content, err := reader.Read(test.path)
+
+		switch {
+		case test.notFound:
+			if !IsNotFound(err) {
+				t.Errorf("(%d) Expected a NotFoundError, got %v", i, err)
+			}
+		case test.invalid:
+			if err == nil || IsNotFound(err) {
+				t.Errorf("(%d) Expected %s to be refused, got %v", i, test.path, err)
+			}
+		case err != nil:
+			t.Errorf("(%d) Unexpected error: %s", i, err)
+		case content != test.expected:
+			t.Errorf("(%d) Wrong content. Expected '%s' got '%s'", i, test.expected, content)
+		}
+	}
+}
+
+func TestDirReader_Stat(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "page.late"), []byte("page"), 0644)
+	when := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, "page.late"), when, when)
+
+	reader := &DirReader{Root: dir, Extension: ".late"}
+	info, err := reader.Stat("page")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !filepath.IsAbs(info.Path) || filepath.Base(info.Path) != "page.late" {
+		t.Errorf("Wrong path, got %s", info.Path)
+	}
+
+	if !info.ModTime.Equal(when) || info.Size != 4 {
+		t.Errorf("Wrong file info, got %+v", info)
+	}
+
+	if _, err := reader.Stat("missing"); !IsNotFound(err) {
+		t.Errorf("Expected a NotFoundError, got %v", err)
+	}
+
+	if _, err := reader.Stat("../page"); err == nil || IsNotFound(err) {
+		t.Errorf("Expected the path to be refused, got %v", err)
+	}
+
+	// Layers can only be searched if they can all Stat
+	layered := LayeredReader{&DirReader{Root: filepath.Join(dir, "nope")}, reader}
+
+	if info, err := layered.Stat("page"); err != nil || info == nil || filepath.Base(info.Path) != "page.late" {
+		t.Errorf("Did not find the file in the second layer, got %+v, %v", info, err)
+	}
+
+	layered = LayeredReader{MapReader{}, reader}
+
+	if info, err := layered.Stat("page"); info != nil || err != nil {
+		t.Errorf("Expected no file info, got %+v, %v", info, err)
+	}
+}
+
+func TestMapReader(t *testing.T) {
+	reader := MapReader{"header": "Header"}
+
+	if content, err := reader.Read("header"); err != nil || content != "Header" {
+		t.Errorf("Wrong content. Got '%s', %v", content, err)
+	}
+
+	if _, err := reader.Read("footer"); !IsNotFound(err) {
+		t.Errorf("Expected a NotFoundError, got %v", err)
+	}
+}
+
+func TestLayeredReader(t *testing.T) {
+	reader := LayeredReader{
+		MapReader{"header": "Custom Header"},
+		MapReader{"header": "Header", "footer": "Footer"},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"header", "Custom Header"},
+		{"footer", "Footer"},
+	}
+
+	for i, test := range tests {
+		content, err := reader.Read(test.path)
+
+		if err != nil || content != test.expected {
+			t.Errorf("(%d) Wrong content. Expected '%s' got '%s', %v", i, test.expected, content, err)
+		}
+	}
+
+	if _, err := reader.Read("sidebar"); !IsNotFound(err) {
+		t.Errorf("Expected a NotFoundError, got %v", err)
+	}
+
+	// Other errors stop the search
+	reader = LayeredReader{new(NullReader), MapReader{"header": "Header"}}
+
+	if _, err := reader.Read("header"); err == nil || IsNotFound(err) {
+		t.Errorf("Expected the reader's error, got %v", err)
+	}
+}
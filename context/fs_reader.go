@@ -0,0 +1,37 @@
+//go:build go1.16
+// +build go1.16
+
+package context
+
+import (
+	goerrors "errors"
+	"io/fs"
+)
+
+/**
+ * FSReader reads files from an fs.FS, such as an embed.FS of templates
+ * compiled into the binary.
+ * Extension works the same as it does for DirReader.
+ * FSReader is not a StatReader: an fs.FS has no stable identity to key
+ * cached partials on, and embedded files don't have modification times,
+ * so partials are kept up to date by comparing their contents instead.
+ */
+type FSReader struct {
+	FS        fs.FS
+	Extension string
+}
+
+func (f *FSReader) Read(name string) (string, error) {
+	clean, err := cleanPath(name, f.Extension)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := fs.ReadFile(f.FS, clean)
+
+	if goerrors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundError{Path: name}
+	}
+
+	return string(content), err
+}
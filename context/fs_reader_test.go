@@ -0,0 +1,48 @@
+//go:build go1.16
+// +build go1.16
+
+package context
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSReader(t *testing.T) {
+	files := fstest.MapFS{
+		"templates/page.late":            {Data: []byte("page")},
+		"templates/partials/header.late": {Data: []byte("header")},
+	}
+
+	reader := &FSReader{FS: files, Extension: ".late"}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"templates/page", "page"},
+		{"templates/page.late", "page"},
+		{"templates/partials/header", "header"},
+	}
+
+	for i, test := range tests {
+		content, err := reader.Read(test.path)
+
+		if err != nil || content != test.expected {
+			t.Errorf("(%d) Wrong content. Expected '%s' got '%s', %v", i, test.expected, content, err)
+		}
+	}
+
+	if _, err := reader.Read("templates/missing"); !IsNotFound(err) {
+		t.Errorf("Expected a NotFoundError, got %v", err)
+	}
+
+	if _, err := reader.Read("../page"); err == nil || IsNotFound(err) {
+		t.Errorf("Expected the path to be refused, got %v", err)
+	}
+
+	// Partials read from an fs.FS are cached by their contents
+	if _, ok := interface{}(reader).(StatReader); ok {
+		t.Errorf("FSReader should not be a StatReader")
+	}
+}
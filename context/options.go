@@ -8,3 +8,9 @@ func Reader(fs FileReader) func(*Context) {
 		ctx.reader = fs
 	}
 }
+
+func Mode(mode ErrorMode) func(*Context) {
+	return func(ctx *Context) {
+		ctx.mode = mode
+	}
+}
@@ -43,15 +43,22 @@ func (s *Scope) ShadowSet(name string, value object.Object) {
 }
 
 func (s *Scope) Get(name string) object.Object {
+	obj, _ := s.Lookup(name)
+	return obj
+}
+
+// Lookup is Get, also reporting whether or not the variable has been set
+// in this scope or any of its parents.
+func (s *Scope) Lookup(name string) (object.Object, bool) {
 	obj, ok := s.assigns[name]
 
 	if !ok {
 		if s.Parent == nil {
-			return object.NULL
+			return object.NULL, false
 		} else {
-			return s.Parent.Get(name)
+			return s.Parent.Lookup(name)
 		}
 	}
 
-	return obj
+	return obj, true
 }
@@ -0,0 +1,170 @@
+package late
+
+import (
+	"sync"
+
+	"github.com/jasonroelofs/late/filter"
+	"github.com/jasonroelofs/late/tag"
+	"github.com/jasonroelofs/late/template/lexer"
+)
+
+type tagFactoryFunc func() tag.Tag
+
+/**
+ * An Engine owns the set of filters and tags available to the templates
+ * rendered through it. Engines are independent of each other, so two services
+ * in the same process can expose completely different filter and tag sets.
+ *
+ * Engines are safe to read from many renders at once. Registering or removing
+ * filters and tags while renders are in flight is also safe, but a render
+ * may or may not see the change.
+ */
+type Engine struct {
+	mutex      sync.RWMutex
+	filters    map[string]*filter.Filter
+	tags       map[string]tagFactoryFunc
+	delimiters lexer.DelimiterSet
+	partials   *PartialCache
+}
+
+// NewEngine builds an Engine with no filters or tags registered.
+// Use DefaultEngine().Clone() to start from the standard library instead.
+func NewEngine() *Engine {
+	return &Engine{
+		filters:    make(map[string]*filter.Filter),
+		tags:       make(map[string]tagFactoryFunc),
+		delimiters: lexer.DefaultDelimiters,
+		partials:   NewPartialCache(),
+	}
+}
+
+// Clone returns a new Engine with a copy of this Engine's filters, tags and delimiters.
+// Changes to the clone do not affect the original and vice versa.
+// The clone starts with an empty partial cache.
+func (e *Engine) Clone() *Engine {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	clone := NewEngine()
+
+	for name, f := range e.filters {
+		clone.filters[name] = f
+	}
+
+	for name, t := range e.tags {
+		clone.tags[name] = t
+	}
+
+	clone.delimiters = e.delimiters
+
+	return clone
+}
+
+func (e *Engine) AddFilter(name string, filterFunc filter.FilterFunc) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.filters[name] = filter.New(filterFunc)
+}
+
+func (e *Engine) RemoveFilter(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.filters, name)
+}
+
+func (e *Engine) FindFilter(name string) *filter.Filter {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.filters[name]
+}
+
+func (e *Engine) AddTag(tag tagFactoryFunc) {
+	newTag := tag()
+	tagRules := newTag.Parse()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.tags[tagRules.TagName] = tag
+
+	// Partials were parsed without knowing about this tag
+	e.partials.PurgeAll()
+}
+
+func (e *Engine) RemoveTag(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.tags, name)
+	e.partials.PurgeAll()
+}
+
+// FindTag returns a brand new instance of the tag registered under name,
+// or nil if there is no such tag.
+func (e *Engine) FindTag(name string) tag.Tag {
+	e.mutex.RLock()
+	tagFactory, ok := e.tags[name]
+	e.mutex.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	return tagFactory()
+}
+
+// SilentTag is true if the named tag never outputs anything itself,
+// so that lines holding nothing but such tags can be left out of the output.
+// See tag.ParseConfig.Silent.
+func (e *Engine) SilentTag(name string) bool {
+	if tag := e.FindTag(name); tag != nil {
+		config := tag.Parse()
+		return config.Block || config.Interrupt || config.Silent
+	}
+
+	e.mutex.RLock()
+	var tagFactories []tagFactoryFunc
+	for _, tagFactory := range e.tags {
+		tagFactories = append(tagFactories, tagFactory)
+	}
+	e.mutex.RUnlock()
+
+	// Sub-tags like else only mark where a block's branches start and end
+	for _, tagFactory := range tagFactories {
+		config := tagFactory().Parse()
+
+		for _, subTag := range config.SubTags {
+			if config.Block && subTag.TagName == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SetDelimiters changes what marks Late code in templates compiled with this
+// Engine. Empty fields fall back to the defaults. Templates can still choose
+// their own delimiters with a pragma; see lexer.DelimiterSet.
+func (e *Engine) SetDelimiters(delimiters lexer.DelimiterSet) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.delimiters = delimiters
+	e.partials.PurgeAll()
+}
+
+func (e *Engine) Delimiters() lexer.DelimiterSet {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.delimiters
+}
+
+// Partials returns the cache of partials compiled by templates using this Engine.
+func (e *Engine) Partials() *PartialCache {
+	return e.partials
+}
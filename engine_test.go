@@ -0,0 +1,116 @@
+package late
+
+import (
+	"testing"
+
+	"github.com/jasonroelofs/late/filter"
+	"github.com/jasonroelofs/late/object"
+	"github.com/jasonroelofs/late/tag"
+	"github.com/jasonroelofs/late/template/lexer"
+)
+
+func TestNewEngineIsEmpty(t *testing.T) {
+	engine := NewEngine()
+
+	if engine.FindFilter("upcase") != nil {
+		t.Fatalf("A new engine should not have any filters")
+	}
+
+	if engine.FindTag("assign") != nil {
+		t.Fatalf("A new engine should not have any tags")
+	}
+}
+
+func TestDefaultEngineHasStandardLibrary(t *testing.T) {
+	engine := DefaultEngine()
+
+	for _, name := range []string{"size", "upcase", "replace"} {
+		if engine.FindFilter(name) == nil {
+			t.Errorf("Default engine is missing the %s filter", name)
+		}
+	}
+
+	for _, name := range []string{"assign", "capture", "if", "include", "promote", "for", "continue", "break"} {
+		if engine.FindTag(name) == nil {
+			t.Errorf("Default engine is missing the %s tag", name)
+		}
+	}
+}
+
+func TestEngineSilentTags(t *testing.T) {
+	engine := DefaultEngine()
+
+	tests := []struct {
+		name   string
+		silent bool
+	}{
+		{"assign", true},
+		{"promote", true},
+		{"if", true},
+		{"elsif", true},
+		{"else", true},
+		{"when", true},
+		{"break", true},
+		{"include", false},
+		{"nope", false},
+	}
+
+	for i, test := range tests {
+		if engine.SilentTag(test.name) != test.silent {
+			t.Errorf("(%d) Expected %s to be silent: %t", i, test.name, test.silent)
+		}
+	}
+}
+
+func TestEngineClonesAreIndependent(t *testing.T) {
+	original := NewEngine()
+	original.AddFilter("upcase", filter.Upcase)
+	original.AddTag(func() tag.Tag { return new(tag.Assign) })
+
+	clone := original.Clone()
+	clone.AddFilter("shout", func(input object.Object, _ filter.Parameters) object.Object {
+		return input
+	})
+	clone.RemoveFilter("upcase")
+	clone.RemoveTag("assign")
+
+	if original.FindFilter("shout") != nil {
+		t.Errorf("Adding a filter to the clone should not affect the original")
+	}
+
+	if original.FindFilter("upcase") == nil {
+		t.Errorf("Removing a filter from the clone should not affect the original")
+	}
+
+	if original.FindTag("assign") == nil {
+		t.Errorf("Removing a tag from the clone should not affect the original")
+	}
+
+	if clone.FindFilter("shout") == nil {
+		t.Errorf("Clone did not register the new filter")
+	}
+
+	if clone.FindFilter("upcase") != nil || clone.FindTag("assign") != nil {
+		t.Errorf("Clone did not remove the filter and tag")
+	}
+}
+
+func TestEngineDelimiters(t *testing.T) {
+	engine := NewEngine()
+
+	if engine.Delimiters() != lexer.DefaultDelimiters {
+		t.Errorf("A new engine should use the default delimiters")
+	}
+
+	engine.SetDelimiters(lexer.DelimiterSet{OpenVar: "[[", CloseVar: "]]"})
+	clone := engine.Clone()
+	clone.SetDelimiters(lexer.DelimiterSet{})
+
+	if engine.Delimiters().OpenVar != "[[" {
+		t.Errorf("Changing the clone's delimiters should not affect the original")
+	}
+
+	if engine.Clone().Delimiters().CloseVar != "]]" {
+		t.Errorf("Clone did not copy the delimiters")
+	}
+}
@@ -0,0 +1,9 @@
+package late
+
+import (
+	"github.com/jasonroelofs/late/errors"
+)
+
+// Error is the structured error type reported by every stage of Late.
+// See the errors package for the full set of error codes.
+type Error = errors.Error
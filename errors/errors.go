@@ -0,0 +1,140 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jasonroelofs/late/template/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// Code is a stable, machine readable identifier for the kind of error.
+// Tooling should switch on the Code rather than parsing the Message.
+type Code string
+
+const (
+	// Lexing
+	IllegalCharacter   Code = "illegal-character"
+	UnterminatedString Code = "unterminated-string"
+	InvalidDelimiters  Code = "invalid-delimiters"
+	InvalidEscape      Code = "invalid-escape"
+
+	// Parsing
+	UnexpectedToken Code = "unexpected-token"
+	UnknownTag      Code = "unknown-tag"
+	InvalidTag      Code = "invalid-tag"
+	InvalidNumber   Code = "invalid-number"
+
+	// Evaluation
+	UnknownFilter     Code = "unknown-filter"
+	UndefinedVariable Code = "undefined-variable"
+	InvalidIndex      Code = "invalid-index"
+	InvalidOperation  Code = "invalid-operation"
+	InvalidArgument   Code = "invalid-argument"
+	PartialNotFound   Code = "partial-not-found"
+
+	// A tag or filter crashed. This is always a bug in the tag or filter.
+	InternalError Code = "internal-error"
+)
+
+/**
+ * Error is the one error type used throughout Late, from lexing through
+ * to evaluation. Along with the message, it keeps track of exactly where in
+ * which template the problem was found.
+ */
+type Error struct {
+	Code     Code
+	Severity Severity
+	Message  string
+
+	// Name of the template the error was found in. Empty if the template
+	// was not given a name.
+	Template string
+
+	// 1-based line and column where the error starts
+	Line   int
+	Column int
+
+	// Byte span of the offending code in the template source
+	Offset int
+	Length int
+
+	// The full line of source code containing the error
+	Snippet string
+
+	annotated bool
+}
+
+// New builds an Error pointing at the given token.
+func New(code Code, tok token.Token, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:     code,
+		Severity: SeverityError,
+		Message:  fmt.Sprintf(format, args...),
+		Line:     tok.Line,
+		Column:   tok.Char,
+		Offset:   tok.Offset,
+		Length:   len(strings.TrimLeft(tok.Raw, " \t\r\n")),
+	}
+}
+
+// Annotate fills in the template name and the source snippet for this error.
+// Errors are only annotated once, so errors bubbling up from included templates
+// keep pointing at the template they were found in.
+func (e *Error) Annotate(templateName, source string) {
+	if e.annotated {
+		return
+	}
+
+	e.annotated = true
+	e.Template = templateName
+	e.Snippet = sourceLine(source, e.Line)
+}
+
+func (e *Error) Error() string {
+	location := fmt.Sprintf("(%d:%d) ", e.Line, e.Column)
+
+	if e.Template != "" {
+		location = e.Template + " " + location
+	}
+
+	return location + e.Message
+}
+
+func sourceLine(source string, line int) string {
+	lines := strings.Split(source, "\n")
+
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// List lets a set of Errors be returned as a single error value.
+type List []*Error
+
+func (l List) Error() string {
+	var messages []string
+
+	for _, err := range l {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
@@ -0,0 +1,63 @@
+package errors
+
+import (
+	"testing"
+
+	"github.com/jasonroelofs/late/template/token"
+)
+
+func TestNew(t *testing.T) {
+	tok := token.Token{Type: token.IDENT, Literal: "thing", Raw: "  thing", Line: 2, Char: 4, Offset: 10}
+	err := New(UnknownTag, tok, "Unknown tag '%s'", "thing")
+
+	if err.Code != UnknownTag || err.Severity != SeverityError {
+		t.Errorf("Wrong code or severity, got %s %s", err.Code, err.Severity)
+	}
+
+	if err.Line != 2 || err.Column != 4 {
+		t.Errorf("Wrong position, got (%d:%d)", err.Line, err.Column)
+	}
+
+	if err.Offset != 10 || err.Length != 5 {
+		t.Errorf("Wrong byte span, got %d+%d", err.Offset, err.Length)
+	}
+
+	if err.Error() != "(2:4) Unknown tag 'thing'" {
+		t.Errorf("Wrong error message, got `%s`", err.Error())
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	err := New(UnknownTag, token.Token{Line: 2, Char: 4}, "Unknown tag")
+	err.Annotate("page.late", "Line one\r\n{% thing %}\nLine three")
+
+	if err.Template != "page.late" {
+		t.Errorf("Did not set the template name, got `%s`", err.Template)
+	}
+
+	if err.Snippet != "{% thing %}" {
+		t.Errorf("Wrong snippet, got `%s`", err.Snippet)
+	}
+
+	if err.Error() != "page.late (2:4) Unknown tag" {
+		t.Errorf("Wrong error message, got `%s`", err.Error())
+	}
+
+	// Errors are only ever annotated once
+	err.Annotate("other.late", "Other")
+
+	if err.Template != "page.late" || err.Snippet != "{% thing %}" {
+		t.Errorf("Annotation was overwritten, got `%s` `%s`", err.Template, err.Snippet)
+	}
+}
+
+func TestList(t *testing.T) {
+	list := List{
+		New(UnknownTag, token.Token{Line: 1, Char: 1}, "One"),
+		New(UnknownTag, token.Token{Line: 2, Char: 1}, "Two"),
+	}
+
+	if list.Error() != "(1:1) One\n(2:1) Two" {
+		t.Errorf("Wrong error message, got `%s`", list.Error())
+	}
+}
@@ -12,6 +12,11 @@ func TestSize(t *testing.T) {
 		expected object.Object
 	}{
 		{object.New("A String"), object.New(8)},
+		{object.New([]int{1, 2, 3}), object.New(3)},
+		{object.New(map[string]int{"a": 1}), object.New(1)},
+		{object.NewRange(1, 10), object.New(10)},
+		{object.NewRange(10, 1), object.New(0)},
+		{object.NewRange(-1000000, 1000000), object.New(2000001)},
 	}
 
 	for _, test := range tests {
@@ -40,3 +45,56 @@ func TestUpcase(t *testing.T) {
 		}
 	}
 }
+
+func TestReplace(t *testing.T) {
+	params := Parameters{"replace": object.New("Mom"), "with": object.New("World")}
+	got := Replace(object.New("Hello Mom"), params)
+
+	if got.Value() != "Hello World" {
+		t.Errorf("Returned the wrong value. Expected %#v got %#v", "Hello World", got)
+	}
+
+	errorTests := []struct {
+		input   object.Object
+		params  Parameters
+		message string
+	}{
+		{object.New(1), params, "replace: input must be a string, got NUMBER"},
+		{object.New("Hi"), Parameters{"replace": object.New("Hi")}, "replace: missing the `with` parameter"},
+		{object.New("Hi"), Parameters{"replace": object.New(1), "with": object.New("")}, "replace: `replace` must be a string, got NUMBER"},
+	}
+
+	for _, test := range errorTests {
+		got := Replace(test.input, test.params)
+
+		if !object.IsError(got) || got.Value() != test.message {
+			t.Errorf("Expected error `%s` got %#v", test.message, got)
+		}
+	}
+}
+
+func TestUniq(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{[]int{}, "[]"},
+		{[]int{1, 2, 1, 3, 2}, "[1,2,3]"},
+		{[]interface{}{1, "1", 1.0}, "[1,1]"},
+		{[]interface{}{[]int{1}, []int{1}, []int{2}}, "[[1],[2]]"},
+	}
+
+	for i, test := range tests {
+		got := Uniq(object.New(test.input), make(Parameters))
+
+		if got.Inspect() != test.expected {
+			t.Errorf("(%d) Returned the wrong value. Expected %s got %s", i, test.expected, got.Inspect())
+		}
+	}
+
+	got := Uniq(object.New("abc"), make(Parameters))
+
+	if !object.IsError(got) || got.Value() != "uniq: input must be an array, got STRING" {
+		t.Errorf("Expected an error, got %#v", got)
+	}
+}
@@ -2,14 +2,22 @@ package filter
 
 import (
 	"strings"
+	"unicode/utf8"
 
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
 )
 
 func Size(input object.Object, _ Parameters) object.Object {
-	switch input.Type() {
-	case object.TYPE_STRING:
-		return object.New(len(input.Value().(string)))
+	switch input := input.(type) {
+	case *object.String:
+		return object.New(utf8.RuneCountInString(input.Inspect()))
+	case *object.Array:
+		return object.New(input.Len())
+	case *object.Hash:
+		return object.New(input.Len())
+	case *object.Range:
+		return object.New(input.Len())
 	default:
 		return input
 	}
@@ -24,12 +32,53 @@ func Upcase(input object.Object, _ Parameters) object.Object {
 	}
 }
 
+// Uniq removes repeated values from an array, keeping the first of each
+func Uniq(input object.Object, _ Parameters) object.Object {
+	array, ok := input.(*object.Array)
+	if !ok {
+		return object.Errorf(errors.InvalidArgument, "uniq: input must be an array, got %s", input.Type())
+	}
+
+	unique := &object.Array{}
+
+	for _, elem := range array.Elements {
+		seen := false
+
+		for _, kept := range unique.Elements {
+			if object.Equal(elem, kept) {
+				seen = true
+				break
+			}
+		}
+
+		if !seen {
+			unique.Append(elem)
+		}
+	}
+
+	return unique
+}
+
 func Replace(input object.Object, params Parameters) object.Object {
-	// TODO Type checking and verification that the parameters are in-fact
-	// Strings and will work here.
-	in := input.Value().(string)
-	replace := params["replace"].Value().(string)
-	with := params["with"].Value().(string)
+	if input.Type() != object.TYPE_STRING {
+		return object.Errorf(errors.InvalidArgument, "replace: input must be a string, got %s", input.Type())
+	}
+
+	for _, name := range []string{"replace", "with"} {
+		param, ok := params[name]
+
+		if !ok {
+			return object.Errorf(errors.InvalidArgument, "replace: missing the `%s` parameter", name)
+		}
+
+		if param.Type() != object.TYPE_STRING {
+			return object.Errorf(errors.InvalidArgument, "replace: `%s` must be a string, got %s", name, param.Type())
+		}
+	}
+
+	in := input.Inspect()
+	replace := params["replace"].Inspect()
+	with := params["with"].Inspect()
 
 	out := strings.Replace(in, replace, with, -1)
 
@@ -5,50 +5,52 @@ import (
 	"github.com/jasonroelofs/late/tag"
 )
 
-type tagFactoryFunc func() tag.Tag
-
-var filters map[string]*filter.Filter
-var tags map[string]tagFactoryFunc
+var defaultEngine *Engine
+
+// DefaultEngine is the Engine preloaded with Late's standard filters and tags.
+// It is used by templates that aren't given an explicit Engine.
+// To customize the set of filters and tags without affecting other templates,
+// Clone() this engine and modify the clone.
+func DefaultEngine() *Engine {
+	return defaultEngine
+}
 
+// AddFilter registers a filter on the default engine.
 func AddFilter(name string, filterFunc filter.FilterFunc) {
-	filters[name] = filter.New(filterFunc)
+	defaultEngine.AddFilter(name, filterFunc)
 }
 
+// FindFilter looks up a filter on the default engine.
 func FindFilter(name string) *filter.Filter {
-	return filters[name]
+	return defaultEngine.FindFilter(name)
 }
 
+// AddTag registers a tag on the default engine.
 func AddTag(tag tagFactoryFunc) {
-	newTag := tag()
-	tagRules := newTag.Parse()
-
-	tags[tagRules.TagName] = tag
+	defaultEngine.AddTag(tag)
 }
 
+// FindTag looks up a tag on the default engine.
 func FindTag(name string) tag.Tag {
-	tagFactory, ok := tags[name]
-	if !ok {
-		return nil
-	}
-
-	return tagFactory()
+	return defaultEngine.FindTag(name)
 }
 
 func init() {
-	filters = make(map[string]*filter.Filter)
-	tags = make(map[string]tagFactoryFunc)
-
-	AddFilter("size", filter.Size)
-	AddFilter("upcase", filter.Upcase)
-	AddFilter("replace", filter.Replace)
-
-	AddTag(func() tag.Tag { return new(tag.Assign) })
-	AddTag(func() tag.Tag { return new(tag.Capture) })
-	AddTag(func() tag.Tag { return new(tag.If) })
-	AddTag(func() tag.Tag { return new(tag.Include) })
-	AddTag(func() tag.Tag { return new(tag.Promote) })
-
-	AddTag(func() tag.Tag { return new(tag.For) })
-	AddTag(func() tag.Tag { return new(tag.Continue) })
-	AddTag(func() tag.Tag { return new(tag.Break) })
+	defaultEngine = NewEngine()
+
+	defaultEngine.AddFilter("size", filter.Size)
+	defaultEngine.AddFilter("upcase", filter.Upcase)
+	defaultEngine.AddFilter("replace", filter.Replace)
+	defaultEngine.AddFilter("uniq", filter.Uniq)
+
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Assign) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Capture) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Case) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.If) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Include) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Promote) })
+
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.For) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Continue) })
+	defaultEngine.AddTag(func() tag.Tag { return new(tag.Break) })
 }
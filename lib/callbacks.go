@@ -0,0 +1,164 @@
+package main
+
+/*
+#include <stdlib.h>
+#include "late.h"
+
+// Go can't call C function pointers directly
+static char *late_call_filter(late_filter_fn fn, const char *input, const char *params, void *user_data, char **error) {
+	return fn(input, params, user_data, error);
+}
+
+static char *late_call_tag(late_tag_fn fn, const char *argument, const char *body, void *user_data, char **error) {
+	return fn(argument, body, user_data, error);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"strings"
+	"unsafe"
+
+	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/filter"
+	"github.com/jasonroelofs/late/object"
+	"github.com/jasonroelofs/late/tag"
+)
+
+/**
+ * A filter implemented in C.
+ */
+type cFilter struct {
+	name     string
+	fn       C.late_filter_fn
+	userData unsafe.Pointer
+}
+
+func (f *cFilter) call(input object.Object, params filter.Parameters) object.Object {
+	nativeParams := make(map[string]interface{}, len(params))
+	for name, param := range params {
+		nativeParams[name] = object.Native(param)
+	}
+
+	inputJSON, err := toJSON(input)
+	if err != nil {
+		return object.Errorf(errors.InvalidArgument, "%s: %s", f.name, err)
+	}
+
+	paramsJSON, err := json.Marshal(nativeParams)
+	if err != nil {
+		return object.Errorf(errors.InvalidArgument, "%s: %s", f.name, err)
+	}
+
+	cInput := C.CString(inputJSON)
+	defer C.free(unsafe.Pointer(cInput))
+
+	cParams := C.CString(string(paramsJSON))
+	defer C.free(unsafe.Pointer(cParams))
+
+	var cErr *C.char
+	result := C.late_call_filter(f.fn, cInput, cParams, f.userData, &cErr)
+
+	output, err := takeResult(result, cErr)
+	if err != nil {
+		return object.Errorf(errors.InvalidOperation, "%s: %s", f.name, err)
+	}
+
+	if output == "" {
+		return object.NULL
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return object.Errorf(errors.InvalidOperation, "%s: returned invalid JSON: %s", f.name, err)
+	}
+
+	return object.New(value)
+}
+
+/**
+ * A tag implemented in C. C tags take at most one expression
+ * and may be blocks, whose content is rendered before the tag is called.
+ */
+type cTag struct {
+	name     string
+	flags    C.int
+	fn       C.late_tag_fn
+	userData unsafe.Pointer
+}
+
+func (t *cTag) Parse() *tag.ParseConfig {
+	config := &tag.ParseConfig{
+		TagName: t.name,
+		Block:   t.flags&C.LATE_TAG_BLOCK != 0,
+	}
+
+	if t.flags&C.LATE_TAG_EXPRESSION != 0 {
+		config.Rules = []tag.ParseRule{tag.Expression()}
+	}
+
+	return config
+}
+
+func (t *cTag) Eval(ctx *context.Context, results *tag.ParseResult) object.Object {
+	argument := "null"
+
+	if len(results.Nodes) > 0 {
+		var err error
+		if argument, err = toJSON(results.Nodes[0]); err != nil {
+			return object.Errorf(errors.InvalidArgument, "%s: %s", t.name, err)
+		}
+	}
+
+	cArgument := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArgument))
+
+	var cBody *C.char
+
+	if t.flags&C.LATE_TAG_BLOCK != 0 {
+		body := strings.Builder{}
+		ctx.EvalAllTo(&body, results.Statements)
+
+		cBody = C.CString(body.String())
+		defer C.free(unsafe.Pointer(cBody))
+	}
+
+	var cErr *C.char
+	result := C.late_call_tag(t.fn, cArgument, cBody, t.userData, &cErr)
+
+	output, err := takeResult(result, cErr)
+	if err != nil {
+		return object.Errorf(errors.InvalidOperation, "%s: %s", t.name, err)
+	}
+
+	return object.New(output)
+}
+
+func toJSON(obj object.Object) (string, error) {
+	encoded, err := json.Marshal(object.Native(obj))
+	return string(encoded), err
+}
+
+// Copy what a callback returned into Go, freeing the C strings.
+func takeResult(result, cErr *C.char) (string, error) {
+	if result != nil {
+		defer C.free(unsafe.Pointer(result))
+	}
+
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return "", callbackError(C.GoString(cErr))
+	}
+
+	if result == nil {
+		return "", nil
+	}
+
+	return C.GoString(result), nil
+}
+
+type callbackError string
+
+func (e callbackError) Error() string { return string(e) }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * cgo doesn't allow C to hold on to Go pointers, so everything handed out
+ * through the C API is stored in a handleTable and referred to by a numeric handle.
+ * Handles are unique across all tables, so a handle of the wrong kind is
+ * never mistaken for another.
+ */
+type handleTable struct {
+	mutex  sync.RWMutex
+	values map[uint64]interface{}
+}
+
+var (
+	lastHandle uint64
+
+	engines   = newHandleTable()
+	templates = newHandleTable()
+	results   = newHandleTable()
+)
+
+func newHandleTable() *handleTable {
+	return &handleTable{values: make(map[uint64]interface{})}
+}
+
+func (h *handleTable) add(value interface{}) uint64 {
+	handle := atomic.AddUint64(&lastHandle, 1)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.values[handle] = value
+
+	return handle
+}
+
+// Returns nil for unknown handles
+func (h *handleTable) get(handle uint64) interface{} {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.values[handle]
+}
+
+// Removes the handle, returning what it pointed to or nil if the handle
+// is unknown. Only one caller ever gets the value back.
+func (h *handleTable) remove(handle uint64) interface{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	value := h.values[handle]
+	delete(h.values, handle)
+
+	return value
+}
@@ -0,0 +1,279 @@
+package main
+
+/*
+#cgo CFLAGS: -DLATE_NO_PROTOTYPES
+#include <stdlib.h>
+#include "late.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"unsafe"
+
+	"github.com/jasonroelofs/late"
+	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/tag"
+	"github.com/jasonroelofs/late/template"
+	"github.com/jasonroelofs/late/template/token"
+)
+
+/**
+ * libLATE exposes Late to other languages through a C API.
+ * See late.h for the API itself. Build with:
+ *
+ *   go build -buildmode=c-shared -o libLATE.so ./lib
+ */
+
+// Required for -buildmode=c-shared
+func main() {}
+
+var errorModes = map[C.int]context.ErrorMode{
+	C.LATE_MODE_WARN:    context.Warn,
+	C.LATE_MODE_STRICT:  context.Strict,
+	C.LATE_MODE_LENIENT: context.Lenient,
+}
+
+/**
+ * Errors are copied into C memory up front, as the strings need to stay
+ * valid for as long as the template or result they came from.
+ */
+type cErrors struct {
+	errors  []C.late_error
+	strings []*C.char
+}
+
+func newCErrors(errs []*errors.Error) *cErrors {
+	list := &cErrors{}
+
+	cString := func(value string) *C.char {
+		str := C.CString(value)
+		list.strings = append(list.strings, str)
+		return str
+	}
+
+	for _, err := range errs {
+		list.errors = append(list.errors, C.late_error{
+			code:          cString(string(err.Code)),
+			severity:      cString(err.Severity.String()),
+			message:       cString(err.Message),
+			template_name: cString(err.Template),
+			line:          C.int(err.Line),
+			column:        C.int(err.Column),
+			offset:        C.int(err.Offset),
+			length:        C.int(err.Length),
+		})
+	}
+
+	return list
+}
+
+func (l *cErrors) get(index C.int, out *C.late_error) C.int {
+	if l == nil || out == nil || index < 0 || int(index) >= len(l.errors) {
+		return -1
+	}
+
+	*out = l.errors[index]
+	return 0
+}
+
+func (l *cErrors) free() {
+	for _, str := range l.strings {
+		C.free(unsafe.Pointer(str))
+	}
+
+	l.strings = nil
+	l.errors = nil
+}
+
+type compiledTemplate struct {
+	template *template.Template
+	errors   *cErrors
+}
+
+type renderResult struct {
+	output *C.char
+	errors *cErrors
+}
+
+func findEngine(handle C.late_engine) *late.Engine {
+	engine, _ := engines.get(uint64(handle)).(*late.Engine)
+	return engine
+}
+
+func findTemplate(handle C.late_template) *compiledTemplate {
+	tpl, _ := templates.get(uint64(handle)).(*compiledTemplate)
+	return tpl
+}
+
+func findResult(handle C.late_result) *renderResult {
+	result, _ := results.get(uint64(handle)).(*renderResult)
+	return result
+}
+
+//export late_engine_new
+func late_engine_new() C.late_engine {
+	return C.late_engine(engines.add(late.DefaultEngine().Clone()))
+}
+
+//export late_engine_free
+func late_engine_free(handle C.late_engine) {
+	engines.remove(uint64(handle))
+}
+
+//export late_engine_add_filter
+func late_engine_add_filter(handle C.late_engine, name *C.char, fn C.late_filter_fn, userData unsafe.Pointer) C.int {
+	engine := findEngine(handle)
+	if engine == nil || name == nil || fn == nil {
+		return -1
+	}
+
+	filter := &cFilter{name: C.GoString(name), fn: fn, userData: userData}
+	engine.AddFilter(filter.name, filter.call)
+
+	return 0
+}
+
+//export late_engine_add_tag
+func late_engine_add_tag(handle C.late_engine, name *C.char, flags C.int, fn C.late_tag_fn, userData unsafe.Pointer) C.int {
+	engine := findEngine(handle)
+	if engine == nil || name == nil || fn == nil {
+		return -1
+	}
+
+	tagName := C.GoString(name)
+	engine.AddTag(func() tag.Tag {
+		return &cTag{name: tagName, flags: flags, fn: fn, userData: userData}
+	})
+
+	return 0
+}
+
+//export late_template_compile
+func late_template_compile(handle C.late_engine, name *C.char, body *C.char) C.late_template {
+	engine := findEngine(handle)
+	if engine == nil || body == nil {
+		return 0
+	}
+
+	var tplName string
+	if name != nil {
+		tplName = C.GoString(name)
+	}
+
+	tpl := template.New(C.GoString(body), template.Name(tplName), template.Engine(engine))
+
+	return C.late_template(templates.add(&compiledTemplate{
+		template: tpl,
+		errors:   newCErrors(tpl.Errors),
+	}))
+}
+
+//export late_template_error_count
+func late_template_error_count(handle C.late_template) C.int {
+	tpl := findTemplate(handle)
+	if tpl == nil {
+		return -1
+	}
+
+	return C.int(len(tpl.errors.errors))
+}
+
+//export late_template_error
+func late_template_error(handle C.late_template, index C.int, out *C.late_error) C.int {
+	tpl := findTemplate(handle)
+	if tpl == nil {
+		return -1
+	}
+
+	return tpl.errors.get(index, out)
+}
+
+//export late_template_free
+func late_template_free(handle C.late_template) {
+	if tpl, ok := templates.remove(uint64(handle)).(*compiledTemplate); ok {
+		tpl.errors.free()
+	}
+}
+
+//export late_template_render
+func late_template_render(handle C.late_template, assignsJSON *C.char, mode C.int) C.late_result {
+	tpl := findTemplate(handle)
+	errorMode, validMode := errorModes[mode]
+
+	if tpl == nil || !validMode {
+		return 0
+	}
+
+	ctx := context.New(context.Mode(errorMode))
+	var output string
+	var renderErrs []*errors.Error
+
+	if assignsJSON != nil {
+		assigns := context.Assigns{}
+
+		if err := json.Unmarshal([]byte(C.GoString(assignsJSON)), &assigns); err != nil {
+			renderErrs = append(renderErrs, errors.New(
+				errors.InvalidArgument, token.Token{}, "Assigns must be a JSON object: %s", err,
+			))
+		}
+
+		ctx.Assign(assigns)
+	}
+
+	if len(renderErrs) == 0 {
+		var err error
+		output, err = tpl.template.Render(ctx)
+
+		switch err := err.(type) {
+		case *errors.Error:
+			renderErrs = []*errors.Error{err}
+		case errors.List:
+			renderErrs = err
+		}
+	}
+
+	return C.late_result(results.add(&renderResult{
+		output: C.CString(output),
+		errors: newCErrors(renderErrs),
+	}))
+}
+
+//export late_result_output
+func late_result_output(handle C.late_result) *C.char {
+	result := findResult(handle)
+	if result == nil {
+		return nil
+	}
+
+	return result.output
+}
+
+//export late_result_error_count
+func late_result_error_count(handle C.late_result) C.int {
+	result := findResult(handle)
+	if result == nil {
+		return -1
+	}
+
+	return C.int(len(result.errors.errors))
+}
+
+//export late_result_error
+func late_result_error(handle C.late_result, index C.int, out *C.late_error) C.int {
+	result := findResult(handle)
+	if result == nil {
+		return -1
+	}
+
+	return result.errors.get(index, out)
+}
+
+//export late_result_free
+func late_result_free(handle C.late_result) {
+	if result, ok := results.remove(uint64(handle)).(*renderResult); ok {
+		C.free(unsafe.Pointer(result.output))
+		result.errors.free()
+	}
+}
@@ -0,0 +1,108 @@
+/**
+ * libLATE: the C interface to the Language Agnostic Template Engine.
+ *
+ * Build the shared library with `make lib`, which produces build/libLATE.so
+ * (or .dylib on macOS) alongside a copy of this header.
+ *
+ * Engines, templates and render results are referred to by opaque handles.
+ * A handle of 0 is never valid. Every handle must be freed with its matching
+ * *_free function. Freeing an engine does not invalidate templates compiled
+ * with it.
+ *
+ * Values cross the library boundary as JSON: render assigns go in as a JSON
+ * object and filters and tags receive and return JSON encoded values.
+ *
+ * All functions are safe to call from multiple threads. Filter and tag
+ * callbacks may be called from any thread, and concurrently if templates
+ * are rendered concurrently.
+ */
+#ifndef LATE_H
+#define LATE_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef uint64_t late_engine;
+typedef uint64_t late_template;
+typedef uint64_t late_result;
+
+/* Error modes for late_template_render */
+#define LATE_MODE_WARN    0
+#define LATE_MODE_STRICT  1
+#define LATE_MODE_LENIENT 2
+
+/* Flags for late_engine_add_tag */
+#define LATE_TAG_BLOCK      1 /* The tag takes a block of content ending in {% end %} */
+#define LATE_TAG_EXPRESSION 2 /* The tag takes a single expression as its argument */
+
+/**
+ * A positioned error from compiling or rendering a template.
+ * The strings are owned by the template or result the error came from
+ * and stay valid until that handle is freed.
+ */
+typedef struct late_error {
+  const char *code;          /* Stable, machine readable code, e.g. "unknown-filter" */
+  const char *severity;      /* "error" or "warning" */
+  const char *message;
+  const char *template_name; /* Empty if the template has no name */
+  int line;                  /* 1-based */
+  int column;                /* 1-based, in characters rather than bytes */
+  int offset;                /* Byte offset into the template source */
+  int length;                /* Byte length of the offending code */
+} late_error;
+
+/**
+ * Filter callbacks receive the JSON encoded input value and a JSON object of
+ * the filter's parameters, and return the JSON encoded result.
+ * Tag callbacks receive their JSON encoded argument ("null" if the tag takes
+ * no expression) and, for block tags, the rendered content of the block
+ * (NULL otherwise). They return the text to output.
+ *
+ * Returned strings must be allocated with malloc; the library frees them.
+ * Returning NULL outputs nothing. To fail, set *error to a malloc'd message.
+ */
+typedef char *(*late_filter_fn)(const char *input_json, const char *params_json, void *user_data, char **error);
+typedef char *(*late_tag_fn)(const char *argument_json, const char *body, void *user_data, char **error);
+
+#ifndef LATE_NO_PROTOTYPES
+
+/* A new engine with the standard filters and tags registered */
+late_engine late_engine_new(void);
+void late_engine_free(late_engine engine);
+
+/* Returns 0 on success and -1 on failure */
+int late_engine_add_filter(late_engine engine, const char *name, late_filter_fn filter, void *user_data);
+int late_engine_add_tag(late_engine engine, const char *name, int flags, late_tag_fn tag, void *user_data);
+
+/**
+ * Compile a template. A template is always returned for a valid engine, even
+ * if there were parse errors; check late_template_error_count.
+ * The name is used in error messages and may be NULL.
+ */
+late_template late_template_compile(late_engine engine, const char *name, const char *body);
+int late_template_error_count(late_template tpl);
+int late_template_error(late_template tpl, int index, late_error *error);
+void late_template_free(late_template tpl);
+
+/**
+ * Render a template with the given assigns, a JSON object or NULL.
+ * Errors are handled according to the mode, as with Template.Render in Go.
+ */
+late_result late_template_render(late_template tpl, const char *assigns_json, int mode);
+
+/* The rendered output, valid until the result is freed */
+const char *late_result_output(late_result result);
+int late_result_error_count(late_result result);
+int late_result_error(late_result result, int index, late_error *error);
+void late_result_free(late_result result);
+
+#endif /* LATE_NO_PROTOTYPES */
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* LATE_H */
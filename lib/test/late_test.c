@@ -0,0 +1,187 @@
+/**
+ * Exercises the libLATE C API. Run with `make lib-test`.
+ */
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "late.h"
+
+static int failures = 0;
+
+#define CHECK(cond, ...)                                   \
+  do {                                                     \
+    if (!(cond)) {                                         \
+      failures++;                                          \
+      fprintf(stderr, "%s:%d: FAILED: ", __FILE__, __LINE__); \
+      fprintf(stderr, __VA_ARGS__);                        \
+      fprintf(stderr, "\n");                               \
+    }                                                      \
+  } while (0)
+
+static char *copy(const char *str) {
+  char *out = malloc(strlen(str) + 1);
+  strcpy(out, str);
+  return out;
+}
+
+/* {{ value | shout }} returns [value, user_data] */
+static char *shout_filter(const char *input_json, const char *params_json, void *user_data, char **error) {
+  const char *suffix = user_data;
+  char *out = malloc(strlen(input_json) + strlen(suffix) + 6);
+
+  sprintf(out, "[%s,\"%s\"]", input_json, suffix);
+  return out;
+}
+
+static char *failing_filter(const char *input_json, const char *params_json, void *user_data, char **error) {
+  *error = copy("this filter always fails");
+  return NULL;
+}
+
+/* {% wrap "tag" %}body{% end %} */
+static char *wrap_tag(const char *argument_json, const char *body, void *user_data, char **error) {
+  char *out = malloc(strlen(argument_json) + strlen(body) + 3);
+
+  sprintf(out, "<%s>%s", argument_json, body);
+  return out;
+}
+
+static char *render(late_template tpl, const char *assigns, int mode, late_result *result_out) {
+  late_result result = late_template_render(tpl, assigns, mode);
+  *result_out = result;
+
+  return (char *)late_result_output(result);
+}
+
+static void test_render(void) {
+  late_engine engine = late_engine_new();
+  CHECK(engine != 0, "Could not create an engine");
+
+  late_template tpl = late_template_compile(engine, "hello", "Hello {{ name | upcase }}! {% for n in nums %}{{ n }}{% end %}");
+  CHECK(tpl != 0, "Could not compile the template");
+  CHECK(late_template_error_count(tpl) == 0, "Unexpected compile errors");
+
+  late_result result;
+  const char *output = render(tpl, "{\"name\": \"World\", \"nums\": [1, 2, 3]}", LATE_MODE_STRICT, &result);
+
+  CHECK(strcmp(output, "Hello WORLD! 123") == 0, "Wrong output: '%s'", output);
+  CHECK(late_result_error_count(result) == 0, "Unexpected render errors");
+
+  late_result_free(result);
+
+  /* Templates can be rendered many times, with or without assigns */
+  output = render(tpl, NULL, LATE_MODE_WARN, &result);
+  CHECK(strcmp(output, "Hello ! ") == 0, "Wrong output: '%s'", output);
+  late_result_free(result);
+
+  /* Strict mode refuses undefined variables, but null is fine */
+  output = render(tpl, NULL, LATE_MODE_STRICT, &result);
+  CHECK(late_result_error_count(result) == 1, "Expected an undefined variable error");
+  late_result_free(result);
+
+  output = render(tpl, "{\"name\": null, \"nums\": []}", LATE_MODE_STRICT, &result);
+  CHECK(strcmp(output, "Hello ! ") == 0, "Wrong output: '%s'", output);
+  late_result_free(result);
+
+  late_template_free(tpl);
+  late_engine_free(engine);
+}
+
+static void test_errors(void) {
+  late_engine engine = late_engine_new();
+  late_template tpl = late_template_compile(engine, "broken.late", "Line one\n{{ 1 + }}");
+  late_error error;
+
+  CHECK(late_template_error_count(tpl) == 1, "Expected one compile error, got %d", late_template_error_count(tpl));
+  CHECK(late_template_error(tpl, 0, &error) == 0, "Could not fetch the compile error");
+  CHECK(strcmp(error.template_name, "broken.late") == 0, "Wrong template name: %s", error.template_name);
+  CHECK(error.line == 2, "Wrong line: %d", error.line);
+  CHECK(strcmp(error.severity, "error") == 0, "Wrong severity: %s", error.severity);
+  CHECK(late_template_error(tpl, 1, &error) == -1, "Fetched an error that does not exist");
+  late_template_free(tpl);
+
+  tpl = late_template_compile(engine, NULL, "A{{ 1 | nope }}B{{ 2 | nope }}C");
+  late_result result;
+  const char *output = render(tpl, NULL, LATE_MODE_WARN, &result);
+
+  CHECK(strcmp(output, "ABC") == 0, "Wrong output: '%s'", output);
+  CHECK(late_result_error_count(result) == 2, "Expected two errors, got %d", late_result_error_count(result));
+  late_result_error(result, 0, &error);
+  CHECK(strcmp(error.code, "unknown-filter") == 0, "Wrong error code: %s", error.code);
+  CHECK(error.line == 1 && error.column == 9, "Wrong position: %d:%d", error.line, error.column);
+  CHECK(strcmp(error.message, "Unknown filter 'nope'") == 0, "Wrong message: %s", error.message);
+  late_result_free(result);
+
+  output = render(tpl, NULL, LATE_MODE_STRICT, &result);
+  CHECK(strcmp(output, "") == 0, "Strict mode rendered output: '%s'", output);
+  CHECK(late_result_error_count(result) == 1, "Expected one error, got %d", late_result_error_count(result));
+  late_result_free(result);
+
+  output = render(tpl, "[1, 2]", LATE_MODE_WARN, &result);
+  late_result_error(result, 0, &error);
+  CHECK(late_result_error_count(result) == 1, "Bad assigns did not error");
+  CHECK(strcmp(error.code, "invalid-argument") == 0, "Wrong error code: %s", error.code);
+  late_result_free(result);
+
+  /* Bad handles are rejected */
+  CHECK(late_template_render(tpl, NULL, 42) == 0, "Accepted an unknown error mode");
+  CHECK(late_template_compile(tpl, NULL, "") == 0, "Accepted a template handle as an engine");
+  CHECK(late_result_output(0) == NULL, "Accepted an invalid result handle");
+  late_result_free(0);
+
+  late_template_free(tpl);
+  late_engine_free(engine);
+}
+
+static void test_callbacks(void) {
+  late_engine engine = late_engine_new();
+  late_engine other = late_engine_new();
+
+  CHECK(late_engine_add_filter(engine, "shout", shout_filter, "!") == 0, "Could not add the filter");
+  CHECK(late_engine_add_filter(engine, "fail", failing_filter, NULL) == 0, "Could not add the filter");
+  CHECK(late_engine_add_tag(engine, "wrap", LATE_TAG_BLOCK | LATE_TAG_EXPRESSION, wrap_tag, NULL) == 0, "Could not add the tag");
+
+  late_template tpl = late_template_compile(engine, NULL, "{% wrap \"p\" %}{{ \"hi\" | shout }}{{ 1 | shout }}{% end %}");
+  CHECK(late_template_error_count(tpl) == 0, "Unexpected compile errors");
+
+  late_result result;
+  const char *output = render(tpl, NULL, LATE_MODE_STRICT, &result);
+  CHECK(strcmp(output, "<\"p\">[hi,!][1,!]") == 0, "Wrong output: '%s'", output);
+  late_result_free(result);
+  late_template_free(tpl);
+
+  tpl = late_template_compile(engine, NULL, "A{{ 1 | fail }}B");
+  output = render(tpl, NULL, LATE_MODE_WARN, &result);
+
+  late_error error;
+  late_result_error(result, 0, &error);
+  CHECK(strcmp(output, "AB") == 0, "Wrong output: '%s'", output);
+  CHECK(strcmp(error.message, "fail: this filter always fails") == 0, "Wrong message: %s", error.message);
+  late_result_free(result);
+  late_template_free(tpl);
+
+  /* Engines don't share filters */
+  tpl = late_template_compile(other, NULL, "{{ 1 | shout }}");
+  output = render(tpl, NULL, LATE_MODE_WARN, &result);
+  CHECK(late_result_error_count(result) == 1, "Filter leaked into another engine");
+  late_result_free(result);
+  late_template_free(tpl);
+
+  late_engine_free(engine);
+  late_engine_free(other);
+}
+
+int main(void) {
+  test_render();
+  test_errors();
+  test_callbacks();
+
+  if (failures > 0) {
+    fprintf(stderr, "%d failure(s)\n", failures);
+    return 1;
+  }
+
+  printf("libLATE: all tests passed\n");
+  return 0;
+}
@@ -3,6 +3,7 @@ package object
 import (
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 type ObjectType string
@@ -14,7 +15,50 @@ type Object interface {
 }
 
 func Truthy(input Object) bool {
-	return input != FALSE && input != NULL
+	return input != FALSE && input != NULL && !IsError(input)
+}
+
+// Equal compares two objects by value. Arrays and Hashes are equal
+// when they hold equal elements, all the way down.
+func Equal(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Array:
+		other, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(other.Elements) {
+			return false
+		}
+
+		for i, elem := range a.Elements {
+			if !Equal(elem, other.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+	case *Hash:
+		other, ok := b.(*Hash)
+		if !ok || len(a.elements) != len(other.elements) {
+			return false
+		}
+
+		for key, value := range a.elements {
+			otherValue, found := other.elements[key]
+			if !found || !Equal(value, otherValue) {
+				return false
+			}
+		}
+
+		return true
+	case *Range:
+		other, ok := b.(*Range)
+		return ok && a.Start == other.Start && a.End == other.End
+	default:
+		return a.Value() == b.Value()
+	}
 }
 
 func New(input interface{}) Object {
@@ -52,6 +96,40 @@ func New(input interface{}) Object {
 	}
 }
 
+// Native converts an Object back into plain Go values, the reverse of New.
+// Arrays and Ranges become []interface{} and Hashes become map[string]interface{},
+// converting their contents along the way.
+func Native(input Object) interface{} {
+	switch input := input.(type) {
+	case *Array:
+		values := make([]interface{}, len(input.Elements))
+
+		for i, elem := range input.Elements {
+			values[i] = Native(elem)
+		}
+
+		return values
+	case *Hash:
+		values := make(map[string]interface{}, len(input.elements))
+
+		for key, value := range input.elements {
+			values[fmt.Sprint(key)] = Native(value)
+		}
+
+		return values
+	case *Range:
+		values := make([]interface{}, input.Len())
+
+		for i := range values {
+			values[i] = Native(input.Get(i))
+		}
+
+		return values
+	default:
+		return input.Value()
+	}
+}
+
 // Pattern from https://stackoverflow.com/a/40178331
 // We want to treat all numbers as float64
 func convertToNative(input interface{}) interface{} {
@@ -86,19 +164,39 @@ func convertToNative(input interface{}) interface{} {
 func tryReflection(input interface{}) Object {
 	v := reflect.ValueOf(input)
 
-	if v.Kind() == reflect.Map {
+	switch v.Kind() {
+	case reflect.Map:
 		return convertFromMap(v)
+	case reflect.Slice, reflect.Array:
+		return convertFromSlice(v)
 	}
 
 	return NULL
 }
 
+func convertFromSlice(input reflect.Value) Object {
+	array := &Array{}
+
+	for i := 0; i < input.Len(); i++ {
+		array.Append(New(input.Index(i).Interface()))
+	}
+
+	return array
+}
+
+// Go maps have no order, so keys are added in sorted order to keep
+// the resulting Hash the same every time.
 func convertFromMap(input reflect.Value) Object {
 	hash := NewHash()
 	var keyObj Object
 	var valueObj Object
 
-	for _, key := range input.MapKeys() {
+	keys := input.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	for _, key := range keys {
 		keyObj = New(key.Interface())
 		valueObj = New(input.MapIndex(key).Interface())
 
@@ -1,6 +1,7 @@
 package object
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -20,7 +21,6 @@ func TestNew(t *testing.T) {
 
 	// To cover:
 	// Unknown types should be converted to strings
-	// Handle array types
 
 	for _, test := range tests {
 		results := New(test.input)
@@ -84,6 +84,77 @@ func TestNew_DeepObjects(t *testing.T) {
 	}
 }
 
+func TestNew_Slices(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{[]interface{}{1, "two", true}, "[1,two,true]"},
+		{[]string{"a", "b"}, "[a,b]"},
+		{[2]int{1, 2}, "[1,2]"},
+		{[]interface{}{[]interface{}{1}, []string{}}, "[[1],[]]"},
+	}
+
+	for i, test := range tests {
+		obj := New(test.input)
+
+		if obj.Type() != TYPE_ARRAY {
+			t.Fatalf("(%d) Did not turn the slice into an Array, got %s", i, obj.Type())
+		}
+
+		if obj.Inspect() != test.expected {
+			t.Errorf("(%d) Wrong array. Expected %s got %s", i, test.expected, obj.Inspect())
+		}
+	}
+}
+
+func TestNative(t *testing.T) {
+	input := map[string]interface{}{
+		"string": "value",
+		"number": 1.5,
+		"bool":   true,
+		"null":   nil,
+		"array":  []interface{}{1.0, "two", []interface{}{}},
+		"hash":   map[string]interface{}{"nested": "yes"},
+	}
+
+	native := Native(New(input))
+
+	if !reflect.DeepEqual(native, input) {
+		t.Errorf("Did not convert back to the original values. Got %#v", native)
+	}
+}
+
+func TestHashKeyOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(New("b"), New(1))
+	hash.Set(New("a"), New(2))
+	hash.Set(New("c"), New(3))
+	hash.Set(New("b"), New(4))
+
+	if keys := New(hash.Keys()).Inspect(); keys != "[b,a,c]" {
+		t.Errorf("Keys are not in the order they were added. Got %s", keys)
+	}
+
+	if inspect := hash.Inspect(); inspect != "{b:4,a:2,c:3}" {
+		t.Errorf("Hash is not inspected in the order keys were added. Got %s", inspect)
+	}
+
+	nested := New(map[string]interface{}{"list": []int{1, 2}, "empty": map[string]int{}})
+	if inspect := nested.Inspect(); inspect != "{empty:{},list:[1,2]}" {
+		t.Errorf("Wrong nested Hash. Got %s", inspect)
+	}
+
+	// Go maps have no order of their own, so their keys are sorted
+	for i := 0; i < 10; i++ {
+		fromMap := New(map[string]int{"z": 1, "y": 2, "x": 3, "w": 4}).(*Hash)
+
+		if keys := New(fromMap.Keys()).Inspect(); keys != "[w,x,y,z]" {
+			t.Fatalf("Keys from a map are not sorted. Got %s", keys)
+		}
+	}
+}
+
 func TestNewReturnsObjectsRaw(t *testing.T) {
 	str := New("A test string")
 	copy := New(str)
@@ -113,3 +184,40 @@ func TestTruthy(t *testing.T) {
 		}
 	}
 }
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b     interface{}
+		expected bool
+	}{
+		{1, 1.0, true},
+		{1, 2, false},
+		{1, "1", false},
+		{"a", "a", true},
+		{true, true, true},
+		{nil, nil, true},
+		{nil, false, false},
+		{[]int{1, 2}, []float64{1, 2}, true},
+		{[]int{1, 2}, []int{2, 1}, false},
+		{[]int{1}, []int{1, 1}, false},
+		{[]interface{}{}, []interface{}{}, true},
+		{[]interface{}{[]int{1}}, []interface{}{[]int{2}}, false},
+		{map[string]int{"a": 1}, map[string]float64{"a": 1}, true},
+		{map[string]int{"a": 1}, map[string]int{"a": 2}, false},
+		{map[string]int{"a": 1}, map[string]int{"b": 1}, false},
+		{map[string]int{"a": 1}, map[string]int{"a": 1, "b": 2}, false},
+		{map[string]interface{}{"a": []int{1}}, map[string]interface{}{"a": []int{1}}, true},
+		{[]int{}, map[string]int{}, false},
+		{NewRange(1, 3), NewRange(1, 3), true},
+		{NewRange(1, 3), NewRange(1, 4), false},
+		{NewRange(1, 3), []int{1, 2, 3}, false},
+	}
+
+	for i, test := range tests {
+		a, b := New(test.a), New(test.b)
+
+		if Equal(a, b) != test.expected || Equal(b, a) != test.expected {
+			t.Errorf("(%d) Expected Equal(%s, %s) to be %t", i, a.Inspect(), b.Inspect(), test.expected)
+		}
+	}
+}
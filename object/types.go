@@ -1,8 +1,11 @@
 package object
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/jasonroelofs/late/errors"
 )
 
 const (
@@ -13,6 +16,9 @@ const (
 	TYPE_FILTER = "FILTER"
 	TYPE_ARRAY  = "ARRAY"
 	TYPE_HASH   = "HASH"
+	TYPE_RANGE  = "RANGE"
+	TYPE_LAZY   = "LAZY"
+	TYPE_ERROR  = "ERROR"
 )
 
 var (
@@ -108,6 +114,10 @@ type Hash struct {
 	// This also means you shouldn't try to make a Hash or Array a key of another
 	// Hash, but why would you want to do such a thing anyway?
 	elements map[interface{}]Object
+
+	// Keys in the order they were first set, so looping over a Hash
+	// always goes in the same order.
+	keys []Object
 }
 
 func NewHash() *Hash {
@@ -125,12 +135,124 @@ func (h *Hash) Get(key Object) Object {
 	return value
 }
 
+func (h *Hash) Has(key Object) bool {
+	_, ok := h.elements[key.Value()]
+	return ok
+}
+
 func (h *Hash) Set(key Object, value Object) {
+	if !h.Has(key) {
+		h.keys = append(h.keys, key)
+	}
+
 	h.elements[key.Value()] = value
 }
 
+// Keys returns the Hash's keys in the order they were added
+func (h *Hash) Keys() []Object {
+	return append([]Object{}, h.keys...)
+}
+
+func (h *Hash) Len() int {
+	return len(h.elements)
+}
+
 func (h *Hash) Type() ObjectType   { return TYPE_HASH }
 func (h *Hash) Value() interface{} { return nil } // TODO?
 func (h *Hash) Inspect() string {
-	return "TODO"
+	output := strings.Builder{}
+
+	var parts []string
+
+	for _, key := range h.keys {
+		parts = append(parts, key.Inspect()+":"+h.Get(key).Inspect())
+	}
+
+	output.WriteString("{")
+	output.WriteString(strings.Join(parts, ","))
+	output.WriteString("}")
+
+	return output.String()
+}
+
+/**
+ * Range is the span of whole numbers from Start to End, including both.
+ * The numbers are only built as they're asked for, so looping over
+ * (1..1000000) doesn't need a million element Array.
+ */
+type Range struct {
+	Start int
+	End   int
+}
+
+func NewRange(start, end int) *Range {
+	return &Range{Start: start, End: end}
 }
+
+// Ranges that end before they start are empty
+func (r *Range) Len() int {
+	if r.End < r.Start {
+		return 0
+	}
+
+	return r.End - r.Start + 1
+}
+
+func (r *Range) Get(index int) Object {
+	return New(r.Start + index)
+}
+
+func (r *Range) Type() ObjectType   { return TYPE_RANGE }
+func (r *Range) Value() interface{} { return nil }
+func (r *Range) Inspect() string {
+	return strconv.Itoa(r.Start) + ".." + strconv.Itoa(r.End)
+}
+
+/**
+ * Lazy is an expression handed to a tag unevaluated, for tags that need to
+ * decide when, or how many times, to evaluate it (see tag.LazyExpression).
+ * Each call to Eval evaluates the expression again, against the variables
+ * in scope at the time.
+ */
+type Lazy struct {
+	Source string
+	eval   func() Object
+}
+
+func NewLazy(source string, eval func() Object) *Lazy {
+	return &Lazy{Source: source, eval: eval}
+}
+
+func (l *Lazy) Eval() Object {
+	return l.eval()
+}
+
+func (l *Lazy) Type() ObjectType   { return TYPE_LAZY }
+func (l *Lazy) Value() interface{} { return nil }
+func (l *Lazy) Inspect() string    { return l.Source }
+
+/**
+ * Error is how filters, tags, and the evaluator itself report problems found
+ * while rendering. Errors flow through evaluation like any other value, skipping
+ * any further work on them, and render as nothing in the output.
+ */
+type Error struct {
+	Code    errors.Code
+	Message string
+
+	// The evaluator fills this in with the full, positioned error
+	// once the Error has been reported.
+	Err *errors.Error
+}
+
+func Errorf(code errors.Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func IsError(obj Object) bool {
+	return obj != nil && obj.Type() == TYPE_ERROR
+}
+
+func (e *Error) Type() ObjectType   { return TYPE_ERROR }
+func (e *Error) Value() interface{} { return e.Message }
+func (e *Error) Inspect() string    { return "" }
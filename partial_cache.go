@@ -0,0 +1,125 @@
+package late
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/template/ast"
+)
+
+/**
+ * PartialCache keeps partials compiled for `include` so they only need to be
+ * parsed once, no matter how many times they're included.
+ * Every Engine has its own cache, as how a template is parsed depends on the
+ * tags the Engine knows about.
+ *
+ * Partials are cached by the path their reader resolved them to (see
+ * context.StatReader) and recompiled when the file changes. Partials from readers
+ * that can't report changes are cached by reader and name, and recompiled when
+ * their content changes.
+ */
+type PartialCache struct {
+	// Kept first for 64-bit alignment, as required by sync/atomic
+	hits   uint64
+	misses uint64
+
+	mutex   sync.RWMutex
+	entries map[string]*CachedPartial
+
+	// Bumped on every purge, so partials that were being compiled while the
+	// cache was purged aren't stored
+	generation uint64
+}
+
+// CachedPartial is one compiled partial, along with what's needed to tell
+// if it's still current.
+type CachedPartial struct {
+	AST    *ast.Template
+	Errors []*errors.Error
+	Body   string
+
+	// Zero if the partial's reader can't report when files change
+	ModTime time.Time
+	Size    int64
+}
+
+// CacheStats reports how well the cache is doing
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+func NewPartialCache() *PartialCache {
+	return &PartialCache{
+		entries: make(map[string]*CachedPartial),
+	}
+}
+
+// Fetch returns the partial cached under key if there is one and isFresh
+// agrees it's still current. Otherwise the partial is compiled and cached.
+// Errors from compile, such as failing to read the partial, are not cached,
+// nor are partials compiled across a purge, as they may be out of date.
+func (c *PartialCache) Fetch(
+	key string,
+	isFresh func(*CachedPartial) bool,
+	compile func() (*CachedPartial, error),
+) (*CachedPartial, error) {
+	c.mutex.RLock()
+	cached, ok := c.entries[key]
+	generation := c.generation
+	c.mutex.RUnlock()
+
+	if ok && isFresh(cached) {
+		atomic.AddUint64(&c.hits, 1)
+		return cached, nil
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+
+	compiled, err := compile()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if c.generation == generation {
+		c.entries[key] = compiled
+	}
+	c.mutex.Unlock()
+
+	return compiled, nil
+}
+
+// Purge removes the partial cached under key, which is the full path of the
+// partial for readers that support context.StatReader, or the reader's ID
+// (see context.Context.ReaderID) and the name it was included by otherwise.
+func (c *PartialCache) Purge(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	delete(c.entries, key)
+}
+
+func (c *PartialCache) PurgeAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*CachedPartial)
+}
+
+func (c *PartialCache) Stats() CacheStats {
+	c.mutex.RLock()
+	entries := len(c.entries)
+	c.mutex.RUnlock()
+
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: entries,
+	}
+}
@@ -0,0 +1,149 @@
+package late
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jasonroelofs/late/tag"
+)
+
+func TestPartialCache_Fetch(t *testing.T) {
+	cache := NewPartialCache()
+	compiles := 0
+
+	fetch := func(key, body string) *CachedPartial {
+		partial, err := cache.Fetch(
+			key,
+			func(cached *CachedPartial) bool { return cached.Body == body },
+			func() (*CachedPartial, error) {
+				compiles++
+				return &CachedPartial{Body: body}, nil
+			},
+		)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		return partial
+	}
+
+	fetch("one", "One")
+	fetch("one", "One")
+	fetch("two", "Two")
+
+	if partial := fetch("one", "One"); partial.Body != "One" || compiles != 2 {
+		t.Errorf("Did not use the cached partial, compiled %d times", compiles)
+	}
+
+	// Stale partials are recompiled
+	if partial := fetch("one", "Changed"); partial.Body != "Changed" || compiles != 3 {
+		t.Errorf("Did not recompile the changed partial, compiled %d times", compiles)
+	}
+
+	expected := CacheStats{Hits: 2, Misses: 3, Entries: 2}
+	if stats := cache.Stats(); stats != expected {
+		t.Errorf("Wrong stats. Expected %+v got %+v", expected, stats)
+	}
+
+	cache.Purge("one")
+	fetch("one", "Changed")
+	fetch("two", "Two")
+
+	if compiles != 4 {
+		t.Errorf("Purge removed the wrong partials, compiled %d times", compiles)
+	}
+
+	cache.PurgeAll()
+	fetch("two", "Two")
+
+	if compiles != 5 || cache.Stats().Entries != 1 {
+		t.Errorf("PurgeAll did not empty the cache, compiled %d times", compiles)
+	}
+}
+
+func TestPartialCache_FetchErrorsAreNotCached(t *testing.T) {
+	cache := NewPartialCache()
+
+	_, err := cache.Fetch(
+		"missing",
+		func(*CachedPartial) bool { return true },
+		func() (*CachedPartial, error) { return nil, fmt.Errorf("not found") },
+	)
+
+	if err == nil || err.Error() != "not found" {
+		t.Errorf("Did not get the compile error, got %v", err)
+	}
+
+	if cache.Stats().Entries != 0 {
+		t.Errorf("Cached a failed compile")
+	}
+}
+
+func TestPartialCache_PurgeDuringCompile(t *testing.T) {
+	cache := NewPartialCache()
+	compiles := 0
+
+	fetch := func(purge bool) *CachedPartial {
+		partial, _ := cache.Fetch(
+			"partial",
+			func(*CachedPartial) bool { return true },
+			func() (*CachedPartial, error) {
+				compiles++
+
+				// Such as another goroutine adding a tag to the engine
+				if purge {
+					cache.PurgeAll()
+				}
+
+				return &CachedPartial{Body: "Partial"}, nil
+			},
+		)
+
+		return partial
+	}
+
+	if partial := fetch(true); partial == nil || partial.Body != "Partial" {
+		t.Fatalf("Did not return the compiled partial, got %+v", partial)
+	}
+
+	if cache.Stats().Entries != 0 {
+		t.Errorf("Cached a partial compiled across a purge")
+	}
+
+	fetch(false)
+	fetch(false)
+
+	if compiles != 2 || cache.Stats().Entries != 1 {
+		t.Errorf("Did not cache the partial after the purge, compiled %d times", compiles)
+	}
+}
+
+func TestEngine_ChangingTagsPurgesPartials(t *testing.T) {
+	engine := NewEngine()
+	cache := engine.Partials()
+
+	store := func() {
+		cache.Fetch("partial", func(*CachedPartial) bool { return true }, func() (*CachedPartial, error) {
+			return &CachedPartial{}, nil
+		})
+	}
+
+	store()
+	engine.AddTag(func() tag.Tag { return new(tag.Assign) })
+
+	if cache.Stats().Entries != 0 {
+		t.Errorf("Adding a tag did not purge the partial cache")
+	}
+
+	store()
+	engine.RemoveTag("assign")
+
+	if cache.Stats().Entries != 0 {
+		t.Errorf("Removing a tag did not purge the partial cache")
+	}
+
+	if engine.Clone().Partials() == cache {
+		t.Errorf("Clones should have their own partial cache")
+	}
+}
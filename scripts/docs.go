@@ -23,7 +23,7 @@ import (
 type TestDoc struct {
 	FilePath string
 	Segments []*Segment
-	Errors   []string
+	Errors   []error
 	Failed   bool
 }
 
@@ -38,22 +38,9 @@ func (s *Segment) Matches() bool {
 	return s.Output == s.Expected
 }
 
-type PartialReader struct {
-	BaseDir string
-}
-
-func (pr *PartialReader) Read(partialPath string) string {
-	content, err := ioutil.ReadFile(path.Join(pr.BaseDir, partialPath+".late"))
-	if err != nil {
-		return "Unable to find partial " + partialPath
-	}
-
-	return string(content)
-}
-
 func main() {
 	docsDir := os.Args[1]
-	reader := &PartialReader{BaseDir: docsDir}
+	reader := &context.DirReader{Root: docsDir, Extension: ".late"}
 
 	var lateFiles []string
 
@@ -94,16 +81,20 @@ func main() {
 			t := template.New(segment.Input)
 			ctx := context.New(context.Reader(reader))
 			ctx.Assign(globalData)
-			segment.Output = t.Render(ctx)
+			output, err := t.Render(ctx)
+			segment.Output = output
 
-			if len(t.Errors) == 0 && segment.Matches() {
+			if err == nil && segment.Matches() {
 				printSuccess()
 			} else {
 				testDoc.Failed = true
 				success = false
 
 				printFailure()
-				testDoc.Errors = t.Errors
+
+				if err != nil {
+					testDoc.Errors = append(testDoc.Errors, err)
+				}
 			}
 		}
 
@@ -147,7 +138,7 @@ func splitDocFile(filePath string) *TestDoc {
 	testDoc := &TestDoc{FilePath: filePath}
 
 	splitRegex := regexp.MustCompile("(?m)^$")
-	removeLeader := regexp.MustCompile(`(?m)^([<>]\s)`)
+	removeLeader := regexp.MustCompile(`(?m)^[<>] ?`)
 	parts := splitRegex.Split(string(content), -1)
 	segment := &Segment{}
 
@@ -21,6 +21,7 @@ func (a *Assign) Parse() *ParseConfig {
 	return &ParseConfig{
 		TagName: "assign",
 		Rules:   []ParseRule{Identifier(), Token(token.ASSIGN), Expression()},
+		Silent:  true,
 	}
 }
 
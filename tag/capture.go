@@ -1,6 +1,8 @@
 package tag
 
 import (
+	"strings"
+
 	"github.com/jasonroelofs/late/context"
 	"github.com/jasonroelofs/late/object"
 )
@@ -29,7 +31,10 @@ func (c *Capture) Parse() *ParseConfig {
 
 func (c *Capture) Eval(ctx *context.Context, results *ParseResult) object.Object {
 	varName := results.Nodes[0].Value().(string)
+	output := strings.Builder{}
+
+	ctx.EvalAllTo(&output, results.Statements)
 
-	ctx.Set(varName, ctx.EvalAll(results.Statements))
+	ctx.Set(varName, output.String())
 	return object.NULL
 }
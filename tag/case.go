@@ -0,0 +1,67 @@
+package tag
+
+import (
+	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/object"
+)
+
+/**
+ * The case tag picks between branches by comparing a single value against
+ * each `when`, evaluating that value only once. A `when` can list several
+ * values, any of which can match. Only the first matching `when` is rendered.
+ *
+ *   {% case product.type %}
+ *   {% when "shirt", "hat" %}
+ *     Clothing
+ *   {% when "mug" %}
+ *     Kitchen
+ *   {% else %}
+ *     Something else
+ *   {% end %}
+ *
+ * Anything between `case` and the first `when` is ignored.
+ */
+type Case struct{}
+
+func (c *Case) Parse() *ParseConfig {
+	return &ParseConfig{
+		TagName: "case",
+		Block:   true,
+		Rules:   []ParseRule{Named("subject", Expression())},
+		SubTags: []ParseConfig{
+			{
+				TagName: "when",
+				Block:   true,
+				Rules:   []ParseRule{Named("values", CommaList(Expression()))},
+			},
+			{
+				TagName: "else",
+				Block:   true,
+				Last:    true,
+			},
+		},
+	}
+}
+
+func (c *Case) Eval(ctx *context.Context, results *ParseResult) object.Object {
+	subject := results.Get("subject")
+
+	for _, subTag := range results.SubTagResults {
+		if subTag.TagName == "else" {
+			return ctx.EvalAll(subTag.Statements)
+		}
+
+		values := subTag.Get("values")
+		if object.IsError(values) {
+			return values
+		}
+
+		for _, value := range values.(*object.Array).Elements {
+			if object.Equal(subject, value) {
+				return ctx.EvalAll(subTag.Statements)
+			}
+		}
+	}
+
+	return object.NULL
+}
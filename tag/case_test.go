@@ -0,0 +1,75 @@
+package tag
+
+import (
+	"testing"
+
+	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/object"
+	s "github.com/jasonroelofs/late/template/statement"
+)
+
+func TestCaseRunsFirstMatchingWhen(t *testing.T) {
+	tag := new(Case)
+	eval := new(TestEval)
+	ctx := context.New()
+	ctx.SetEvaluator(eval)
+
+	results := &ParseResult{
+		TagName:    "case",
+		Named:      map[string]object.Object{"subject": object.New(2)},
+		Statements: []s.Statement{&TestStatement{Out: "Ignored"}},
+		SubTagResults: []*ParseResult{
+			&ParseResult{
+				TagName:    "when",
+				Named:      map[string]object.Object{"values": object.New([]interface{}{1, "2"})},
+				Statements: []s.Statement{&TestStatement{Out: "Statement 1"}},
+			},
+			&ParseResult{
+				TagName:    "when",
+				Named:      map[string]object.Object{"values": object.New([]interface{}{3, 2})},
+				Statements: []s.Statement{&TestStatement{Out: "Statement 2"}},
+			},
+			&ParseResult{
+				TagName:    "else",
+				Statements: []s.Statement{&TestStatement{Out: "Statement 3"}},
+			},
+		},
+	}
+
+	result := tag.Eval(ctx, results).(*object.Array)
+	if result.Get(0).Value() != "Statement 2" {
+		t.Fatalf("Did not execute the matching when block, got %v", result)
+	}
+
+	if len(eval.StatementsRan) != 1 {
+		t.Fatalf("Ran more than the matching when block, ran %v", eval.StatementsRan)
+	}
+}
+
+func TestCaseFallsBackToElse(t *testing.T) {
+	tag := new(Case)
+	eval := new(TestEval)
+	ctx := context.New()
+	ctx.SetEvaluator(eval)
+
+	results := &ParseResult{
+		TagName: "case",
+		Named:   map[string]object.Object{"subject": object.New("mug")},
+		SubTagResults: []*ParseResult{
+			&ParseResult{
+				TagName:    "when",
+				Named:      map[string]object.Object{"values": object.New([]interface{}{"hat"})},
+				Statements: []s.Statement{&TestStatement{Out: "Statement 1"}},
+			},
+			&ParseResult{
+				TagName:    "else",
+				Statements: []s.Statement{&TestStatement{Out: "Statement 2"}},
+			},
+		},
+	}
+
+	result := tag.Eval(ctx, results).(*object.Array)
+	if result.Get(0).Value() != "Statement 2" {
+		t.Fatalf("Did not execute the else block, got %v", result)
+	}
+}
@@ -1,21 +1,32 @@
 package tag
 
 import (
-	"strings"
-
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
 )
 
 var (
-	FIRST  = object.New("first")
-	LAST   = object.New("last")
-	INDEX  = object.New("index")
-	LENGTH = object.New("length")
+	FIRST      = object.New("first")
+	LAST       = object.New("last")
+	INDEX      = object.New("index")
+	INDEX1     = object.New("index1")
+	RINDEX     = object.New("rindex")
+	RINDEX0    = object.New("rindex0")
+	LENGTH     = object.New("length")
+	PARENTLOOP = object.New("parentloop")
 )
 
 /**
- * The for loop
+ * The for loop, over an Array, a Range, or a Hash.
+ * Hashes are looped over in the order their keys were added, either as
+ * [key, value] pairs or with two variables:
+ *
+ *   {% for key, value in hash %}
+ *
+ * As in Liquid, `offset: n` skips the first n entries, `limit: n` stops after
+ * n entries, and `reversed` loops over what's left from last to first.
+ * The `else` block is rendered instead when there is nothing to loop over.
  */
 type For struct{}
 
@@ -23,17 +34,84 @@ func (f *For) Parse() *ParseConfig {
 	return &ParseConfig{
 		TagName: "for",
 		Block:   true,
-		Rules:   []ParseRule{Identifier(), Literal("in"), Expression()},
+		Rules: []ParseRule{
+			Named("variables", IdentifierList()),
+			Literal("in"),
+			Named("collection", Expression()),
+			Repeat(OneOf(KeywordArgs("limit", "offset"), Flag("reversed"))),
+		},
+		SubTags: []ParseConfig{
+			{
+				TagName: "else",
+				Block:   true,
+				Last:    true,
+			},
+		},
 	}
 }
 
 func (f *For) Eval(ctx *context.Context, results *ParseResult) object.Object {
-	varName := results.Nodes[0].Value().(string)
+	var varNames []string
+	for _, name := range results.Get("variables").(*object.Array).Elements {
+		varNames = append(varNames, name.Inspect())
+	}
+
+	if len(varNames) > 2 {
+		return object.Errorf(errors.InvalidArgument, "Expected one or two loop variables, got %d", len(varNames))
+	}
+
+	collection := results.Get("collection")
+
+	if collection == object.NULL {
+		// Nothing to loop over
+		return f.evalElse(ctx, results)
+	}
+
+	// Ranges are looped over without building up an Array of every number
+	var length int
+	var entryAt func(int) object.Object
+
+	switch collection := collection.(type) {
+	case *object.Array:
+		length, entryAt = collection.Len(), collection.Get
+	case *object.Range:
+		length, entryAt = collection.Len(), collection.Get
+	case *object.Hash:
+		// Adding keys inside of the loop doesn't change what we loop over
+		keys := collection.Keys()
+		length = len(keys)
+		entryAt = func(idx int) object.Object {
+			return &object.Array{Elements: []object.Object{keys[idx], collection.Get(keys[idx])}}
+		}
+	default:
+		return object.Errorf(errors.InvalidArgument, "Cannot loop over a value of type %s", collection.Type())
+	}
 
-	// TODO: Error if collection isn't an Array
-	// Also TODO: Support iteration over a Hash
-	collection := results.Nodes[2].(*object.Array)
-	output := strings.Builder{}
+	if len(varNames) == 2 && collection.Type() != object.TYPE_HASH {
+		return object.Errorf(errors.InvalidArgument, "Only a Hash can be looped over with two variables, got %s", collection.Type())
+	}
+
+	offset, err := loopOption(results, "offset", 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := loopOption(results, "limit", length)
+	if err != nil {
+		return err
+	}
+
+	// Work out which of the collection's entries we loop over
+	start := min(offset, length)
+	length = min(limit, length-start)
+	reversed := results.Get("reversed") == object.TRUE
+
+	if length == 0 {
+		return f.evalElse(ctx, results)
+	}
+
+	// Nested loops can get to the loop they're in through `forloop.parentloop`
+	parentLoop := ctx.Get("forloop")
 
 	// Set up our shadow scope that keeps `forloop` and the loop variable
 	// scoped to this for loop but allows users to assign values to the template's
@@ -41,18 +119,37 @@ func (f *For) Eval(ctx *context.Context, results *ParseResult) object.Object {
 	ctx.PushShadowScope()
 
 	forLoopInfo := object.NewHash()
-	forLoopInfo.Set(LENGTH, object.New(len(collection.Elements)))
+	forLoopInfo.Set(LENGTH, object.New(length))
+	forLoopInfo.Set(PARENTLOOP, parentLoop)
 	ctx.ShadowSet("forloop", forLoopInfo)
 
 loop:
-	for idx, entry := range collection.Elements {
-		ctx.ShadowSet(varName, entry)
+	for idx := 0; idx < length; idx++ {
+		entry := entryAt(start + idx)
+		if reversed {
+			entry = entryAt(start + length - idx - 1)
+		}
+
+		if len(varNames) == 2 {
+			pair := entry.(*object.Array)
+			ctx.ShadowSet(varNames[0], pair.Get(0))
+			ctx.ShadowSet(varNames[1], pair.Get(1))
+		} else {
+			ctx.ShadowSet(varNames[0], entry)
+		}
 
 		forLoopInfo.Set(INDEX, object.New(idx))
+		forLoopInfo.Set(INDEX1, object.New(idx+1))
+		forLoopInfo.Set(RINDEX, object.New(length-idx))
+		forLoopInfo.Set(RINDEX0, object.New(length-idx-1))
 		forLoopInfo.Set(FIRST, object.New(idx == 0))
-		forLoopInfo.Set(LAST, object.New(idx == len(collection.Elements)-1))
+		forLoopInfo.Set(LAST, object.New(idx == length-1))
+
+		ctx.EvalAll(results.Statements)
 
-		output.WriteString(ctx.EvalAll(results.Statements).Inspect())
+		if ctx.Halted() {
+			break loop
+		}
 
 		switch ctx.Interrupt() {
 		case "continue":
@@ -66,7 +163,48 @@ loop:
 
 	ctx.PopScope()
 
-	return object.New(output.String())
+	return object.NULL
+}
+
+func (f *For) evalElse(ctx *context.Context, results *ParseResult) object.Object {
+	for _, subTag := range results.SubTagResults {
+		if subTag.TagName == "else" {
+			return ctx.EvalAll(subTag.Statements)
+		}
+	}
+
+	return object.NULL
+}
+
+// Offset and limit must be whole numbers. Negative numbers count as zero.
+func loopOption(results *ParseResult, name string, fallback int) (int, object.Object) {
+	value := results.Get(name)
+	if value == object.NULL {
+		return fallback, nil
+	}
+
+	number, isNumber := value.Value().(float64)
+	if !isNumber || number != float64(int(number)) {
+		return 0, object.Errorf(errors.InvalidArgument, "The for loop's %s must be a whole number, got %s", name, value.Inspect())
+	}
+
+	return max(int(number), 0), nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
 }
 
 /**
@@ -24,6 +24,7 @@ func (i *If) Parse() *ParseConfig {
 			{
 				TagName: "else",
 				Block:   true,
+				Last:    true,
 			},
 		},
 	}
@@ -1,6 +1,7 @@
 package tag
 
 import (
+	"io"
 	"testing"
 
 	"github.com/jasonroelofs/late/context"
@@ -34,8 +35,10 @@ func (t *TestEval) Eval(stmt s.Statement) object.Object {
 	t.StatementsRan = append(t.StatementsRan, stmt)
 	return object.New(stmt.String())
 }
-func (t *TestEval) Interrupt() string { return "" }
-func (t *TestEval) ClearInterrupt()   {}
+func (t *TestEval) EvalAllTo(_ io.Writer, stmts []s.Statement) { t.EvalAll(stmts) }
+func (t *TestEval) Write(p []byte) (int, error)                { return len(p), nil }
+func (t *TestEval) Interrupt() string                          { return "" }
+func (t *TestEval) ClearInterrupt()                            {}
 
 func TestExpressionsAreTruthy(t *testing.T) {
 	tag := new(If)
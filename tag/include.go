@@ -2,6 +2,7 @@ package tag
 
 import (
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
 )
 
@@ -15,22 +16,25 @@ func (i *Include) Parse() *ParseConfig {
 }
 
 func (i *Include) Eval(ctx *context.Context, results *ParseResult) object.Object {
-	// TODO: Make sure we have a string here
-	// something like,
-	//
-	//   paritalName, err := object.GetString(result.Nodes[0])
-	//   paritalName, err := object.Get(result.Nodes[0], object.TYPE_STRING)
-	//
+	if results.Nodes[0].Type() != object.TYPE_STRING {
+		return object.Errorf(errors.InvalidArgument, "Partial names must be strings, got %s", results.Nodes[0].Type())
+	}
 
-	partialName := results.Nodes[0].Value().(string)
-	partialBody := ctx.ReadFile(partialName)
+	partialName := results.Nodes[0].Inspect()
 
 	ctx.PushScope()
 
-	// Set up a new context stack?
-	result := ctx.Render(partialBody)
+	err := ctx.Render(partialName)
 
 	ctx.PopScope()
 
-	return object.New(result)
+	if context.IsNotFound(err) {
+		return object.Errorf(errors.PartialNotFound, "Could not find partial '%s'", partialName)
+	}
+
+	if err != nil {
+		return object.Errorf(errors.InvalidArgument, "Could not read partial '%s': %s", partialName, err)
+	}
+
+	return object.NULL
 }
@@ -21,6 +21,8 @@ type ParseConfig struct {
 	// Rules contains a list of rules informing the parser how to parse
 	// the rest of the immediate tag. The rules will be mapped into object.Object records
 	// and passed into Eval() during the evaluation phase.
+	// Rules can be made optional, repeated or combined with the combinators below,
+	// and given names with Named to be found by name in the ParseResult.
 	Rules []ParseRule
 
 	// Block tags can also have sub-tags to provide for conditional execution (e.g. if/elsif/else).
@@ -28,10 +30,19 @@ type ParseConfig struct {
 	// will be provided in the SubTagResults value of ParseResult.
 	SubTags []ParseConfig
 
+	// Set Last on a sub-tag that has to come after all of the others, like `else`.
+	// Nothing, not even another of the same sub-tag, can follow it.
+	Last bool
+
 	// Flag this tag as one that will fire an Interrupt. When this tag is hit in the evaluator,
 	// all further evaluation will halt until another tag handles and clears the interrupt.
 	// For examples, see Continue and Break handling in the For tag.
 	Interrupt bool
+
+	// Set Silent on tags that never output anything, like assign. A line holding
+	// nothing but silent tags is left out of the output, newline and all.
+	// Block tags, their sub-tags and interrupts are always silent.
+	Silent bool
 }
 
 // ParseResult is passed into the tags Eval() method during evaulation phase.
@@ -43,6 +54,10 @@ type ParseResult struct {
 	// was provided in the ParseConfig.Rules field.
 	Nodes []object.Object
 
+	// Named holds the results of Named rules, KeywordArgs and Flags, by name.
+	// Use Get to read them.
+	Named map[string]object.Object
+
 	// For block-type tags, this list of statements correspond to the content of the
 	// block and should be evaulated in order according to the rules of the tag.
 	Statements []s.Statement
@@ -52,6 +67,16 @@ type ParseResult struct {
 	SubTagResults []*ParseResult
 }
 
+// Get returns the named result, or object.NULL if the tag was used
+// without it.
+func (r *ParseResult) Get(name string) object.Object {
+	if value, ok := r.Named[name]; ok {
+		return value
+	}
+
+	return object.NULL
+}
+
 /**
  * Parsing Rules
  * The following constructs are how tags define to the template how to parse and evaluate
@@ -75,7 +100,84 @@ type TokenRule struct {
 type ExpressionRule struct {
 }
 
+// An expression that is not evaluated before the tag's Eval, resulting in
+// an *object.Lazy the tag can evaluate itself.
+type LazyExpressionRule struct {
+}
+
+// A bare word, e.g. the `reversed` of a for loop.
+// Named by the word itself, with a value of true.
+type FlagRule struct {
+	Name string
+}
+
 func Identifier() ParseRule             { return &IdentifierRule{} }
 func Token(t token.TokenType) ParseRule { return &TokenRule{Type: t} }
 func Literal(value string) ParseRule    { return &LiteralRule{Value: value} }
 func Expression() ParseRule             { return &ExpressionRule{} }
+func LazyExpression() ParseRule         { return &LazyExpressionRule{} }
+func Flag(name string) ParseRule        { return &FlagRule{Name: name} }
+
+/**
+ * Combinators build rules out of other rules:
+ *
+ *   // {% render "card" %} or {% render "card" with product %}
+ *   Rules: []ParseRule{Expression(), Optional(Literal("with"), Named("with", Expression()))}
+ *
+ *   // {% cycle "odd", "even" group: "rows" %}
+ *   Rules: []ParseRule{Named("values", CommaList(Expression())), KeywordArgs("group")}
+ *
+ * Optional, OneOf and Repeat decide whether to go on by looking at the next
+ * token only. A rule that can match nothing, such as KeywordArgs, is skipped
+ * over by them unless the next token starts it.
+ */
+
+// Rules to match in order, or not at all if the next token doesn't start the first of them.
+// Results in the result of its rule, or an Array of the results when given
+// several rules. Results in null when not matched.
+type OptionalRule struct {
+	Rules []ParseRule
+}
+
+// The first rule that the next token starts.
+// Results in that rule's result.
+type OneOfRule struct {
+	Rules []ParseRule
+}
+
+// Zero or more matches of a rule.
+// Results in an Array of each match's result.
+type RepeatRule struct {
+	Rule ParseRule
+}
+
+// One or more matches of a rule, separated by commas, e.g. `key, value`.
+// Results in an Array of each match's result.
+type CommaListRule struct {
+	Rule ParseRule
+}
+
+// Zero or more `name: expression` pairs, optionally separated by commas.
+// Each pair is named by its name. With no Names, any name is accepted, so
+// nothing starting with an identifier can follow it.
+// Results in a Hash of the pairs.
+type KeywordArgsRule struct {
+	Names []string
+}
+
+// Makes a rule's result available by name through ParseResult.Get
+type NamedRule struct {
+	Name string
+	Rule ParseRule
+}
+
+func Optional(rules ...ParseRule) ParseRule       { return &OptionalRule{Rules: rules} }
+func OneOf(rules ...ParseRule) ParseRule          { return &OneOfRule{Rules: rules} }
+func Repeat(rule ParseRule) ParseRule             { return &RepeatRule{Rule: rule} }
+func CommaList(rule ParseRule) ParseRule          { return &CommaListRule{Rule: rule} }
+func KeywordArgs(names ...string) ParseRule       { return &KeywordArgsRule{Names: names} }
+func Named(name string, rule ParseRule) ParseRule { return &NamedRule{Name: name, Rule: rule} }
+
+// One or more identifiers separated by commas, e.g. `key, value`.
+// Results in an Array of the identifiers' names.
+func IdentifierList() ParseRule { return CommaList(Identifier()) }
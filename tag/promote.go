@@ -21,6 +21,7 @@ func (p *Promote) Parse() *ParseConfig {
 	return &ParseConfig{
 		TagName: "promote",
 		Rules:   []ParseRule{Identifier()},
+		Silent:  true,
 	}
 }
 
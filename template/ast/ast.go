@@ -38,6 +38,11 @@ type Expression interface {
 // Template is always the root node of the AST.
 type Template struct {
 	Statements []Statement
+
+	// The name of the template, if it has one, and the original source text.
+	// Used to point errors back to where they came from.
+	Name   string
+	Source string
 }
 
 func (t *Template) AddStatement(stmt Statement) {
@@ -90,12 +95,21 @@ type TagStatement struct {
 	Owner   *TagStatement
 
 	Nodes          []Expression
+	Named          []*NamedNode
 	BlockStatement *BlockStatement
 	SubTags        []*TagStatement
 }
 
 func (t *TagStatement) statementNode() {}
 
+// A node of the tag that was given a name by its parse rules.
+// The same node is also found in the tag's Nodes.
+type NamedNode struct {
+	Token token.Token
+	Name  string
+	Value Expression
+}
+
 func (t *TagStatement) HasSubTag(tagName string) bool {
 	rules := t.Tag.Parse()
 
@@ -145,6 +159,45 @@ func (t *TagStatement) String() string {
 	return out.String()
 }
 
+// The values matched by a tag's Repeat, CommaList or KeywordArgs parse rules.
+// Keyword arguments also have Keys.
+type TagValues struct {
+	Token  token.Token
+	Keys   []string
+	Values []Expression
+}
+
+func (t *TagValues) expressionNode() {}
+func (t *TagValues) String() string {
+	var parts []string
+
+	for i, value := range t.Values {
+		if t.Keys != nil {
+			parts = append(parts, t.Keys[i]+": "+value.String())
+		} else {
+			parts = append(parts, value.String())
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// An expression matched by a tag's LazyExpression parse rule,
+// which the tag evaluates itself.
+type LazyExpression struct {
+	Token      token.Token
+	Expression Expression
+}
+
+func (l *LazyExpression) expressionNode() {}
+func (l *LazyExpression) String() string {
+	if l.Expression == nil {
+		return ""
+	}
+
+	return l.Expression.String()
+}
+
 // Some tags can be Interrupts that will halt the current
 // block of code and return what's been evaluated up until that point.
 // For examples, see `continue` and `break`
@@ -183,6 +236,11 @@ func (p *PrefixExpression) String() string {
 	out := strings.Builder{}
 
 	out.WriteString(p.Operator)
+
+	if p.Token.Type == token.NOT && p.Operator != "!" {
+		out.WriteString(" ")
+	}
+
 	out.WriteString(p.Right.String())
 
 	return out.String()
@@ -206,6 +264,18 @@ func (i *InfixExpression) String() string {
 	return out.String()
 }
 
+// A span of whole numbers, e.g. (1..5), including both ends
+type RangeLiteral struct {
+	Token token.Token
+	Start Expression
+	End   Expression
+}
+
+func (r *RangeLiteral) expressionNode() {}
+func (r *RangeLiteral) String() string {
+	return "(" + r.Start.String() + ".." + r.End.String() + ")"
+}
+
 type IndexExpression struct {
 	Token token.Token
 	Left  Expression
@@ -291,6 +361,13 @@ type BooleanLiteral struct {
 func (b *BooleanLiteral) expressionNode() {}
 func (b *BooleanLiteral) String() string  { return b.Token.Literal }
 
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode() {}
+func (n *NullLiteral) String() string  { return n.Token.Literal }
+
 type FilterLiteral struct {
 	Token      token.Token
 	Name       string
@@ -330,6 +407,33 @@ func (f *FilterLiteral) String() string {
 	return out.String()
 }
 
+type HashLiteral struct {
+	Token token.Token
+	Pairs []HashPair
+}
+
+// Keys are always strings, whether or not they were quoted in the template.
+type HashPair struct {
+	Key   *StringLiteral
+	Value Expression
+}
+
+func (h *HashLiteral) expressionNode() {}
+func (h *HashLiteral) String() string {
+	output := strings.Builder{}
+	var parts []string
+
+	for _, pair := range h.Pairs {
+		parts = append(parts, pair.Key.String()+": "+pair.Value.String())
+	}
+
+	output.WriteString("{")
+	output.WriteString(strings.Join(parts, ", "))
+	output.WriteString("}")
+
+	return output.String()
+}
+
 type ArrayLiteral struct {
 	Token       token.Token
 	Expressions []Expression
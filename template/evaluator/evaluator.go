@@ -1,46 +1,94 @@
 package evaluator
 
 import (
+	"io"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/jasonroelofs/late"
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/object"
 	"github.com/jasonroelofs/late/tag"
 	"github.com/jasonroelofs/late/template/ast"
 	s "github.com/jasonroelofs/late/template/statement"
+	"github.com/jasonroelofs/late/template/token"
 )
 
 type Evaluator struct {
 	context  *context.Context
 	template *ast.Template
+	engine   *late.Engine
+
+	// Where rendered output is currently being written, and the first
+	// error seen writing to it. Once writing fails, evaluation stops.
+	out      io.Writer
+	writeErr error
 
 	currentInterrupt *ast.InterruptStatement
 }
 
-func New(template *ast.Template, context *context.Context) *Evaluator {
-	e := &Evaluator{
+func New(template *ast.Template, context *context.Context, engine *late.Engine) *Evaluator {
+	return &Evaluator{
 		template: template,
 		context:  context,
+		engine:   engine,
 	}
-
-	context.SetEvaluator(e)
-
-	return e
 }
 
+// Run evaluates the template, returning the output of each top level statement.
+// Output that block tags stream while they run is gathered up into their
+// statement's result.
 // TODO: Return an object.Array?
 func (e *Evaluator) Run() []object.Object {
 	var objects []object.Object
 
+	defer e.activate()()
+
 	for _, statement := range e.template.Statements {
+		out := strings.Builder{}
+		e.out = &out
+
 		result := e.eval(statement)
+
+		if out.Len() > 0 {
+			result = object.New(out.String() + result.Inspect())
+		}
+
 		objects = append(objects, result)
 	}
 
 	return objects
 }
 
+// RunTo evaluates the template, writing output to w as it is rendered.
+// The only error returned is one from writing to w; template errors are
+// recorded in the context.
+func (e *Evaluator) RunTo(w io.Writer) error {
+	defer e.activate()()
+
+	e.out = w
+
+	for _, statement := range e.template.Statements {
+		e.write(e.eval(statement))
+	}
+
+	return e.writeErr
+}
+
+// Make this evaluator the one the context, and so tags, talk to.
+// Partials render with their own evaluator in the same context, so the
+// returned func puts back whichever evaluator was there before.
+func (e *Evaluator) activate() func() {
+	previous := e.context.Evaluator()
+	e.context.SetEvaluator(e)
+
+	return func() {
+		e.context.SetEvaluator(previous)
+	}
+}
+
 func (e *Evaluator) Interrupt() string {
 	if e.currentInterrupt == nil {
 		return ""
@@ -53,9 +101,9 @@ func (e *Evaluator) ClearInterrupt() {
 	e.currentInterrupt = nil
 }
 
+// EvalAll evaluates each statement in turn, writing their output to the
+// current output.
 func (e *Evaluator) EvalAll(statements []s.Statement) object.Object {
-	out := strings.Builder{}
-
 	for _, stmt := range statements {
 		interrupt, isInterrupt := stmt.(*ast.InterruptStatement)
 
@@ -64,10 +112,40 @@ func (e *Evaluator) EvalAll(statements []s.Statement) object.Object {
 			break
 		}
 
-		out.WriteString(e.Eval(stmt).Inspect())
+		e.write(e.Eval(stmt))
 	}
 
-	return object.New(out.String())
+	return object.NULL
+}
+
+// EvalAllTo is EvalAll, with output written to w instead.
+func (e *Evaluator) EvalAllTo(w io.Writer, statements []s.Statement) {
+	out := e.out
+	e.out = w
+
+	e.EvalAll(statements)
+
+	e.out = out
+}
+
+// Statements that output nothing, like assign, don't write at all
+func (e *Evaluator) write(obj object.Object) {
+	if output := obj.Inspect(); output != "" {
+		io.WriteString(e, output)
+	}
+}
+
+// Write writes straight to the current output, so that partials render
+// into the template that included them.
+func (e *Evaluator) Write(p []byte) (int, error) {
+	if e.writeErr != nil {
+		return 0, e.writeErr
+	}
+
+	var n int
+	n, e.writeErr = e.out.Write(p)
+
+	return n, e.writeErr
 }
 
 func (e *Evaluator) Eval(node s.Statement) object.Object {
@@ -75,14 +153,14 @@ func (e *Evaluator) Eval(node s.Statement) object.Object {
 }
 
 func (e *Evaluator) eval(node ast.Node) object.Object {
-	if e.Interrupt() != "" {
+	if e.Interrupt() != "" || e.writeErr != nil || e.context.Halted() {
 		return object.NULL
 	}
 
 	switch node := node.(type) {
 	// Top-level Statements
 	case *ast.RawStatement:
-		return object.New(node.String())
+		return object.New(node.Token.Literal)
 
 	case *ast.VariableStatement:
 		return e.eval(node.Expression)
@@ -93,22 +171,67 @@ func (e *Evaluator) eval(node ast.Node) object.Object {
 	// Expressions
 	case *ast.InfixExpression:
 		left := e.eval(node.Left)
+		if object.IsError(left) {
+			return left
+		}
+
+		if node.Token.Type == token.AND || node.Token.Type == token.OR {
+			return e.evalLogical(node, left)
+		}
+
 		right := e.eval(node.Right)
-		return e.evalInfix(node.Operator, left, right)
+		if object.IsError(right) {
+			return right
+		}
+
+		return e.evalInfix(node, left, right)
 
 	case *ast.PrefixExpression:
 		right := e.eval(node.Right)
-		return e.evalPrefix(node.Operator, right)
+		if object.IsError(right) {
+			return right
+		}
+
+		return e.evalPrefix(node, right)
 
 	case *ast.FilterExpression:
 		input := e.eval(node.Input)
+		if object.IsError(input) {
+			return input
+		}
+
 		filter := e.eval(node.Filter)
-		return e.evalFilter(input, filter)
+		if object.IsError(filter) {
+			return filter
+		}
+
+		return e.evalFilter(node, input, filter)
 
 	case *ast.IndexExpression:
 		left := e.eval(node.Left)
+		if object.IsError(left) {
+			return left
+		}
+
 		index := e.eval(node.Index)
-		return e.evalIndex(left, index)
+		if object.IsError(index) {
+			return index
+		}
+
+		return e.evalIndex(node, left, index)
+
+	case *ast.RangeLiteral:
+		start := e.eval(node.Start)
+		if object.IsError(start) {
+			return start
+		}
+
+		end := e.eval(node.End)
+		if object.IsError(end) {
+			return end
+		}
+
+		return e.evalRange(node, start, end)
 
 	// Literals
 	case *ast.NumberLiteral:
@@ -120,8 +243,11 @@ func (e *Evaluator) eval(node ast.Node) object.Object {
 	case *ast.StringLiteral:
 		return object.New(node.Value)
 
+	case *ast.NullLiteral:
+		return object.NULL
+
 	case *ast.Identifier:
-		return e.evalIdentifier(node.Value)
+		return e.evalIdentifier(node)
 
 	case *ast.FilterLiteral:
 		return e.evalFilterLiteral(node)
@@ -129,30 +255,52 @@ func (e *Evaluator) eval(node ast.Node) object.Object {
 	case *ast.ArrayLiteral:
 		return e.evalArrayLiteral(node)
 
+	case *ast.HashLiteral:
+		return e.evalHashLiteral(node)
+
 	default:
 		return object.NULL
 	}
 }
 
 func (e *Evaluator) evalTagStatement(node *ast.TagStatement) object.Object {
-	return node.Tag.Eval(e.context, e.prepareTagResults(node))
+	results := e.prepareTagResults(node)
+
+	for _, result := range results.Nodes {
+		if object.IsError(result) {
+			return result
+		}
+	}
+
+	for _, result := range results.Named {
+		if object.IsError(result) {
+			return result
+		}
+	}
+
+	result := e.safely(node.Token, "Tag '"+node.TagName+"'", func() object.Object {
+		return node.Tag.Eval(e.context, results)
+	})
+
+	return e.report(node.Token, result)
 }
 
 func (e *Evaluator) prepareTagResults(node *ast.TagStatement) *tag.ParseResult {
 	var results []object.Object
+	values := make(map[ast.Expression]object.Object)
 
 	for _, node := range node.Nodes {
-		switch node := node.(type) {
-		case *ast.Identifier:
-			results = append(results, e.context.Get(node.Value))
-		default:
-			results = append(results, e.eval(node))
-		}
+		results = append(results, e.evalTagNode(node, values))
 	}
 
 	parseResults := &tag.ParseResult{
 		TagName: node.TagName,
 		Nodes:   results,
+		Named:   make(map[string]object.Object),
+	}
+
+	for _, named := range node.Named {
+		parseResults.Named[named.Name] = e.evalTagNode(named.Value, values)
 	}
 
 	if node.BlockStatement != nil {
@@ -171,23 +319,149 @@ func (e *Evaluator) prepareTagResults(node *ast.TagStatement) *tag.ParseResult {
 	return parseResults
 }
 
-func (e *Evaluator) evalInfix(operator string, left, right object.Object) object.Object {
+// Named nodes are also part of the tag's Nodes, so remember what each node
+// evaluated to rather than evaluating it twice.
+func (e *Evaluator) evalTagNode(node ast.Expression, values map[ast.Expression]object.Object) object.Object {
+	if value, ok := values[node]; ok {
+		return value
+	}
+
+	var value object.Object
+
+	switch node := node.(type) {
+	case *ast.LazyExpression:
+		value = object.NewLazy(node.String(), func() object.Object {
+			return e.report(node.Token, e.eval(node.Expression))
+		})
+	case *ast.TagValues:
+		value = e.evalTagValues(node, values)
+	default:
+		value = e.eval(node)
+	}
+
+	values[node] = value
+	return value
+}
+
+func (e *Evaluator) evalTagValues(node *ast.TagValues, values map[ast.Expression]object.Object) object.Object {
+	array := &object.Array{}
+	hash := object.NewHash()
+
+	for i, expr := range node.Values {
+		value := e.evalTagNode(expr, values)
+		if object.IsError(value) {
+			return value
+		}
+
+		if node.Keys != nil {
+			hash.Set(object.New(node.Keys[i]), value)
+		} else {
+			array.Elements = append(array.Elements, value)
+		}
+	}
+
+	if node.Keys != nil {
+		return hash
+	}
+
+	return array
+}
+
+func (e *Evaluator) evalInfix(node *ast.InfixExpression, left, right object.Object) object.Object {
+	operator := node.Operator
+
 	switch {
+	case node.Token.Type == token.CONTAINS:
+		return e.evalContains(node, left, right)
 	case left.Type() == object.TYPE_NUMBER && right.Type() == object.TYPE_NUMBER:
-		return e.evalNumberOperation(operator, left, right)
+		return e.evalNumberOperation(node, left, right)
+	case left.Type() == object.TYPE_STRING && right.Type() == object.TYPE_STRING:
+		return e.evalStringOperation(node, left, right)
+	case operator == "+" && isConcatenation(left, right):
+		return object.New(left.Inspect() + right.Inspect())
 	case operator == "==":
-		return object.New(left.Value() == right.Value())
+		return object.New(object.Equal(left, right))
 	case operator == "!=":
-		return object.New(left.Value() != right.Value())
+		return object.New(!object.Equal(left, right))
 	default:
-		return object.NULL
+		return e.runtimeErrorf(errors.InvalidOperation, node.Token,
+			"Unknown operation: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func (e *Evaluator) evalNumberOperation(operator string, left, right object.Object) object.Object {
+// `contains` checks for a substring, an element of an array,
+// or a key of a hash.
+func (e *Evaluator) evalContains(node *ast.InfixExpression, left, right object.Object) object.Object {
+	switch left.Type() {
+	case object.TYPE_STRING:
+		switch right.Type() {
+		case object.TYPE_STRING, object.TYPE_NUMBER:
+			return object.New(strings.Contains(left.Inspect(), right.Inspect()))
+		default:
+			return object.FALSE
+		}
+	case object.TYPE_ARRAY:
+		for _, element := range left.(*object.Array).Elements {
+			if looselyEqual(element, right) {
+				return object.TRUE
+			}
+		}
+
+		return object.FALSE
+	case object.TYPE_HASH:
+		return object.New(left.(*object.Hash).Has(right))
+	case object.TYPE_NULL:
+		// Like indexing, looking in a value that isn't there finds nothing
+		return object.FALSE
+	default:
+		return e.runtimeErrorf(errors.InvalidOperation, node.Token,
+			"Unknown operation: %s contains %s", left.Type(), right.Type())
+	}
+}
+
+// Array elements match numbers given as strings and vice versa,
+// so `[1, 2, 3] contains "3"` is true.
+func looselyEqual(a, b object.Object) bool {
+	switch {
+	case a.Type() == object.TYPE_NUMBER && b.Type() == object.TYPE_STRING:
+		number, err := strconv.ParseFloat(b.Inspect(), 64)
+		return err == nil && number == a.Value()
+	case a.Type() == object.TYPE_STRING && b.Type() == object.TYPE_NUMBER:
+		return looselyEqual(b, a)
+	default:
+		return object.Equal(a, b)
+	}
+}
+
+// `and` and `or` only evaluate their right side if they need to
+func (e *Evaluator) evalLogical(node *ast.InfixExpression, left object.Object) object.Object {
+	leftTruthy := object.Truthy(left)
+
+	if node.Token.Type == token.AND && !leftTruthy {
+		return object.FALSE
+	}
+
+	if node.Token.Type == token.OR && leftTruthy {
+		return object.TRUE
+	}
+
+	right := e.eval(node.Right)
+	if object.IsError(right) {
+		return right
+	}
+
+	return object.New(object.Truthy(right))
+}
+
+func (e *Evaluator) evalNumberOperation(node *ast.InfixExpression, left, right object.Object) object.Object {
+	operator := node.Operator
 	leftVal := left.Value().(float64)
 	rightVal := right.Value().(float64)
 
+	if rightVal == 0 && (operator == "/" || operator == "//" || operator == "%") {
+		return e.runtimeErrorf(errors.InvalidOperation, node.Token, "Cannot divide %s by zero", left.Inspect())
+	}
+
 	switch operator {
 	case "+":
 		return object.New(leftVal + rightVal)
@@ -197,6 +471,16 @@ func (e *Evaluator) evalNumberOperation(operator string, left, right object.Obje
 		return object.New(leftVal * rightVal)
 	case "/":
 		return object.New(leftVal / rightVal)
+	case "//":
+		return object.New(math.Floor(leftVal / rightVal))
+	case "%":
+		// Keep the sign of the divisor, so that a == (a // b) * b + a % b
+		mod := math.Mod(leftVal, rightVal)
+		if mod != 0 && (mod < 0) != (rightVal < 0) {
+			mod += rightVal
+		}
+
+		return object.New(mod)
 	case ">":
 		return object.New(leftVal > rightVal)
 	case "<":
@@ -214,12 +498,47 @@ func (e *Evaluator) evalNumberOperation(operator string, left, right object.Obje
 	}
 }
 
-func (e *Evaluator) evalPrefix(operator string, right object.Object) object.Object {
+// Strings compare lexicographically, byte by byte
+func (e *Evaluator) evalStringOperation(node *ast.InfixExpression, left, right object.Object) object.Object {
+	leftVal := left.Value().(string)
+	rightVal := right.Value().(string)
+
+	switch node.Operator {
+	case "+":
+		return object.New(leftVal + rightVal)
+	case ">":
+		return object.New(leftVal > rightVal)
+	case "<":
+		return object.New(leftVal < rightVal)
+	case ">=":
+		return object.New(leftVal >= rightVal)
+	case "<=":
+		return object.New(leftVal <= rightVal)
+	case "==":
+		return object.New(leftVal == rightVal)
+	case "!=":
+		return object.New(leftVal != rightVal)
+	default:
+		return e.runtimeErrorf(errors.InvalidOperation, node.Token,
+			"Unknown operation: %s %s %s", left.Type(), node.Operator, right.Type())
+	}
+}
+
+// Adding a string and a number joins them together as strings: "Chapter " + 1
+func isConcatenation(left, right object.Object) bool {
+	return left.Type() == object.TYPE_STRING && right.Type() == object.TYPE_NUMBER ||
+		left.Type() == object.TYPE_NUMBER && right.Type() == object.TYPE_STRING
+}
+
+func (e *Evaluator) evalPrefix(node *ast.PrefixExpression, right object.Object) object.Object {
 	switch {
+	case node.Token.Type == token.NOT:
+		return object.New(!object.Truthy(right))
 	case right.Type() == object.TYPE_NUMBER:
-		return e.evalNumberPrefix(operator, right)
+		return e.evalNumberPrefix(node.Operator, right)
 	default:
-		return object.NULL
+		return e.runtimeErrorf(errors.InvalidOperation, node.Token,
+			"Unknown operation: %s%s", node.Operator, right.Type())
 	}
 }
 
@@ -232,8 +551,16 @@ func (e *Evaluator) evalNumberPrefix(operator string, right object.Object) objec
 	}
 }
 
-func (e *Evaluator) evalIdentifier(name string) object.Object {
-	return e.context.Get(name)
+// Variables that were never defined are empty, except in Strict mode where
+// they're an error. Variables explicitly set to null are always fine.
+func (e *Evaluator) evalIdentifier(node *ast.Identifier) object.Object {
+	value, defined := e.context.Lookup(node.Value)
+
+	if !defined && e.context.ErrorMode() == context.Strict {
+		return e.runtimeErrorf(errors.UndefinedVariable, node.Token, "Undefined variable '%s'", node.Value)
+	}
+
+	return value
 }
 
 func (e *Evaluator) evalFilterLiteral(node *ast.FilterLiteral) object.Object {
@@ -243,34 +570,54 @@ func (e *Evaluator) evalFilterLiteral(node *ast.FilterLiteral) object.Object {
 	}
 
 	for paramName, paramExp := range node.Parameters {
-		filterObj.Parameters[paramName] = e.eval(paramExp)
+		param := e.eval(paramExp)
+		if object.IsError(param) {
+			return param
+		}
+
+		filterObj.Parameters[paramName] = param
 	}
 
 	return filterObj
 }
 
-func (e *Evaluator) evalFilter(input, filter object.Object) object.Object {
+func (e *Evaluator) evalFilter(node *ast.FilterExpression, input, filter object.Object) object.Object {
 	filterName := filter.(*object.Filter).Name
 	filterParams := filter.(*object.Filter).Parameters
 
-	filterFunc := late.FindFilter(filterName)
+	filterFunc := e.engine.FindFilter(filterName)
+
+	filterToken := node.Filter.(*ast.FilterLiteral).Token
 
 	if filterFunc == nil {
-		return object.NULL
+		return e.runtimeErrorf(errors.UnknownFilter, filterToken, "Unknown filter '%s'", filterName)
 	}
 
-	return filterFunc.Call(input, filterParams)
+	result := e.safely(filterToken, "Filter '"+filterName+"'", func() object.Object {
+		return filterFunc.Call(input, filterParams)
+	})
+
+	return e.report(filterToken, result)
 }
 
-func (e *Evaluator) evalIndex(left, index object.Object) object.Object {
+func (e *Evaluator) evalIndex(node *ast.IndexExpression, left, index object.Object) object.Object {
 	switch {
+	case index.Type() == object.TYPE_RANGE && (left.Type() == object.TYPE_ARRAY || left.Type() == object.TYPE_STRING):
+		return e.evalSlice(left, index.(*object.Range))
 	case left.Type() == object.TYPE_ARRAY && index.Type() == object.TYPE_NUMBER:
 		return e.evalArrayAccess(left, index)
+	case left.Type() == object.TYPE_ARRAY:
+		return e.runtimeErrorf(errors.InvalidIndex, node.Token,
+			"Arrays can only be indexed by numbers, got %s", index.Type())
 	case left.Type() == object.TYPE_HASH:
 		return e.evalHashAccess(left, index)
-	default:
-		// Unknown action "index" on this object
+	case left.Type() == object.TYPE_NULL:
+		// Digging into a value that isn't there gives us nothing back,
+		// so `{{ user.name }}` is empty when there's no user.
 		return object.NULL
+	default:
+		return e.runtimeErrorf(errors.InvalidIndex, node.Token,
+			"Cannot index into a value of type %s", left.Type())
 	}
 }
 
@@ -285,6 +632,59 @@ func (e *Evaluator) evalArrayAccess(left, index object.Object) object.Object {
 	return array.Elements[idx]
 }
 
+// Slicing keeps the elements, or characters, from the range's start to its end.
+// Any part of the range past either end is ignored.
+func (e *Evaluator) evalSlice(left object.Object, span *object.Range) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		from, to := sliceBounds(span, len(left.Elements))
+		return &object.Array{Elements: append([]object.Object{}, left.Elements[from:to]...)}
+	default:
+		chars := []rune(left.Inspect())
+		from, to := sliceBounds(span, len(chars))
+		return object.New(string(chars[from:to]))
+	}
+}
+
+func sliceBounds(span *object.Range, length int) (int, int) {
+	clamp := func(value, min, max int) int {
+		if value < min {
+			return min
+		}
+
+		if value > max {
+			return max
+		}
+
+		return value
+	}
+
+	from := clamp(span.Start, 0, length)
+	return from, clamp(span.End+1, from, length)
+}
+
+func (e *Evaluator) evalRange(node *ast.RangeLiteral, start, end object.Object) object.Object {
+	var bounds []int
+
+	for _, bound := range []object.Object{start, end} {
+		value, ok := bound.Value().(float64)
+
+		if !ok || value != math.Trunc(value) {
+			description := string(bound.Type())
+			if ok {
+				description = bound.Inspect()
+			}
+
+			return e.runtimeErrorf(errors.InvalidOperation, node.Token,
+				"Ranges can only be made of whole numbers, got %s", description)
+		}
+
+		bounds = append(bounds, int(value))
+	}
+
+	return object.NewRange(bounds[0], bounds[1])
+}
+
 func (e *Evaluator) evalHashAccess(left, index object.Object) object.Object {
 	hash := left.(*object.Hash)
 
@@ -295,8 +695,70 @@ func (e *Evaluator) evalArrayLiteral(node *ast.ArrayLiteral) object.Object {
 	array := &object.Array{}
 
 	for _, expr := range node.Expressions {
-		array.Elements = append(array.Elements, e.eval(expr))
+		element := e.eval(expr)
+		if object.IsError(element) {
+			return element
+		}
+
+		array.Elements = append(array.Elements, element)
 	}
 
 	return array
 }
+
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral) object.Object {
+	hash := object.NewHash()
+
+	for _, pair := range node.Pairs {
+		value := e.eval(pair.Value)
+		if object.IsError(value) {
+			return value
+		}
+
+		hash.Set(object.New(pair.Key.Value), value)
+	}
+
+	return hash
+}
+
+// Build an error object for a problem found while evaluating the template,
+// reporting it right away.
+func (e *Evaluator) runtimeErrorf(code errors.Code, tok token.Token, format string, args ...interface{}) object.Object {
+	return e.report(tok, object.Errorf(code, format, args...))
+}
+
+// If the given object is an error that hasn't been reported yet, give it the position
+// of the token that caused it and record it in the context. Errors are only reported
+// once no matter how far up the tree they are passed.
+func (e *Evaluator) report(tok token.Token, obj object.Object) object.Object {
+	objErr, ok := obj.(*object.Error)
+	if !ok || objErr.Err != nil {
+		return obj
+	}
+
+	objErr.Err = errors.New(objErr.Code, tok, "%s", objErr.Message)
+	objErr.Err.Annotate(e.template.Name, e.template.Source)
+
+	e.context.AddError(objErr.Err)
+
+	return objErr
+}
+
+// Tags and filters can come from anywhere, so make sure a crash in one of them
+// is reported as an error in the template instead of taking down the whole process.
+func (e *Evaluator) safely(tok token.Token, what string, run func() object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = object.Errorf(errors.InternalError, "%s crashed: %v", what, r)
+		}
+	}()
+
+	result = run()
+
+	// Treat a tag or filter that returns nothing like one that returns NULL
+	if result == nil {
+		result = object.NULL
+	}
+
+	return
+}
@@ -5,7 +5,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/jasonroelofs/late"
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/filter"
 	"github.com/jasonroelofs/late/object"
 	"github.com/jasonroelofs/late/template/lexer"
 	"github.com/jasonroelofs/late/template/parser"
@@ -32,6 +35,15 @@ func TestNumbers(t *testing.T) {
 		{"{{ (2 + 3) * 5 }}", 25},
 		{"{{ -1 }}", -1},
 		{"{{ -(12 + 3) }}", -15},
+		{"{{ 7 / 2 }}", 3.5},
+		{"{{ 7 // 2 }}", 3},
+		{"{{ -7 // 2 }}", -4},
+		{"{{ 7.5 // 2 }}", 3},
+		{"{{ 7 % 3 }}", 1},
+		{"{{ -7 % 3 }}", 2},
+		{"{{ 7 % -3 }}", -2},
+		{"{{ 7.5 % 2 }}", 1.5},
+		{"{{ 1 + 10 % 4 * 2 }}", 5},
 	}
 
 	for _, test := range tests {
@@ -60,6 +72,15 @@ func TestBooleans(t *testing.T) {
 		{`{{ "this" == "this" }}`, true},
 		{`{{ "this" != "that" }}`, true},
 		{`{{ "this" == "that" }}`, false},
+		{"{{ [1, 2] == [1, 2] }}", true},
+		{"{{ [1] != [2] }}", true},
+		{"{{ [1] == [1, 2] }}", false},
+		{`{{ [1] == ["1"] }}`, false},
+		{"{{ {a: [1]} == {a: [1]} }}", true},
+		{"{{ {a: 1} == {a: 1, b: 2} }}", false},
+		{"{{ {a: 1} != {b: 1} }}", true},
+		{"{{ [] == {} }}", false},
+		{`{{ 1 == "1" }}`, false},
 	}
 
 	for _, test := range tests {
@@ -70,6 +91,210 @@ func TestBooleans(t *testing.T) {
 	}
 }
 
+func TestNull(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType object.ObjectType
+		expected     interface{}
+	}{
+		{"{{ null }}", object.TYPE_NULL, nil},
+		{"{{ nil }}", object.TYPE_NULL, nil},
+		{"{{ null == nil }}", object.TYPE_BOOL, true},
+		{"{{ missing == null }}", object.TYPE_BOOL, true},
+		{"{{ 1 == null }}", object.TYPE_BOOL, false},
+		{"{{ not null }}", object.TYPE_BOOL, true},
+	}
+
+	for _, test := range tests {
+		results := evalInput(t, test.input, context.New())
+
+		checkStatementCount(t, results, 1)
+		checkObject(t, results[0], test.expectedType, test.expected)
+	}
+}
+
+func TestUndefinedVariables(t *testing.T) {
+	tests := []struct {
+		input  string
+		mode   context.ErrorMode
+		errors int
+	}{
+		{"{{ missing }}", context.Warn, 0},
+		{"{{ missing }}", context.Lenient, 0},
+		{"{{ missing }}", context.Strict, 1},
+		{"{{ missing.field }}", context.Strict, 1},
+		{"{% if missing %}{% end %}", context.Strict, 1},
+		{"{{ nothing }}", context.Strict, 0},
+		{"{{ nothing.field }}", context.Strict, 0},
+		{"{% assign later = null %}{{ later }}", context.Strict, 0},
+		{"{{ site.missing }}", context.Strict, 0},
+	}
+
+	for i, test := range tests {
+		ctx := context.New(context.Mode(test.mode))
+		ctx.Set("nothing", nil)
+		ctx.Set("site", map[string]interface{}{})
+
+		evalInput(t, test.input, ctx)
+
+		if len(ctx.Errors()) != test.errors {
+			t.Fatalf("(%d) Expected %d errors got %v", i, test.errors, ctx.Errors())
+		}
+
+		if test.errors > 0 && ctx.Errors()[0].Code != errors.UndefinedVariable {
+			t.Errorf("(%d) Wrong error, got %s", i, ctx.Errors()[0])
+		}
+	}
+}
+
+func TestStringOperations(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType object.ObjectType
+		expected     interface{}
+	}{
+		{`{{ "Hello" + " " + name }}`, object.TYPE_STRING, "Hello Jane"},
+		{`{{ "Chapter " + 1 }}`, object.TYPE_STRING, "Chapter 1"},
+		{`{{ 2.5 + "kg" }}`, object.TYPE_STRING, "2.5kg"},
+		{`{{ "a" + 1 + 2 }}`, object.TYPE_STRING, "a12"},
+		{`{{ "a" + (1 + 2) }}`, object.TYPE_STRING, "a3"},
+		{`{{ 1 + 2 + "a" }}`, object.TYPE_STRING, "3a"},
+		{`{{ "apple" < "banana" }}`, object.TYPE_BOOL, true},
+		{`{{ "apple" > "Apple" }}`, object.TYPE_BOOL, true},
+		{`{{ "app" < "apple" }}`, object.TYPE_BOOL, true},
+		{`{{ "10" < "9" }}`, object.TYPE_BOOL, true},
+		{`{{ name <= "Jane" }}`, object.TYPE_BOOL, true},
+		{`{{ name >= "m" }}`, object.TYPE_BOOL, false},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		ctx.Set("name", "Jane")
+
+		results := evalInput(t, test.input, ctx)
+		checkStatementCount(t, results, 1)
+
+		if results[0].Type() != test.expectedType || results[0].Value() != test.expected {
+			t.Errorf("(%d) Expected %v got %#v", i, test.expected, results[0])
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`{{ "Hello World" contains "World" }}`, true},
+		{`{{ "Hello World" contains "world" }}`, false},
+		{`{{ "Hello" contains "" }}`, true},
+		{`{{ "Route 66" contains 66 }}`, true},
+		{`{{ "Hello" contains null }}`, false},
+		{`{{ [1, 2, 3] contains 2 }}`, true},
+		{`{{ [1, 2, 3] contains "3" }}`, true},
+		{`{{ [1, 2, 3] contains "three" }}`, false},
+		{`{{ ["1", "2"] contains 1 }}`, true},
+		{`{{ ["a", true] contains true }}`, true},
+		{`{{ [[1]] contains [1] }}`, true},
+		{`{{ [[1]] contains ["1"] }}`, false},
+		{`{{ [{a: [1]}] contains {a: [1]} }}`, true},
+		{`{{ [] contains 1 }}`, false},
+		{`{{ {sale: true} contains "sale" }}`, true},
+		{`{{ {sale: false} contains "sale" }}`, true},
+		{`{{ {sale: true} contains "new" }}`, false},
+		{`{{ missing contains "x" }}`, false},
+		{`{{ tags contains "sale" and 1 < 2 }}`, true},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		ctx.Set("tags", []string{"new", "sale"})
+
+		results := evalInput(t, test.input, ctx)
+
+		checkStatementCount(t, results, 1)
+
+		if results[0].Type() != object.TYPE_BOOL || results[0].Value() != test.expected {
+			t.Errorf("(%d) Expected %v got %#v", i, test.expected, results[0])
+		}
+	}
+
+	ctx := context.New()
+	evalInput(t, `{{ 12 contains 1 }}`, ctx)
+
+	if len(ctx.Errors()) != 1 || ctx.Errors()[0].Code != errors.InvalidOperation {
+		t.Errorf("Expected an invalid operation error, got %v", ctx.Errors())
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"{{ true and true }}", true},
+		{"{{ true and false }}", false},
+		{"{{ false or true }}", true},
+		{"{{ false or false }}", false},
+		{"{{ true && false }}", false},
+		{"{{ false || true }}", true},
+		{"{{ 1 < 3 and 2 > 4 }}", false},
+		{"{{ 1 < 3 or 2 > 4 }}", true},
+		{"{{ true and false and true or true }}", true},
+		{"{{ true and (false and (true or true)) }}", false},
+		{"{{ false or true and false }}", false},
+		{"{{ not true }}", false},
+		{"{{ !false }}", true},
+		{"{{ not 0 }}", false},
+		{`{{ not "" }}`, false},
+		{"{{ not missing }}", true},
+		{"{{ !(1 > 2) and true }}", true},
+		{"{{ not 1 > 2 }}", true},
+		{"{{ not [1, 2] contains 5 }}", true},
+		{"{{ not [1, 5] contains 5 or false }}", false},
+
+		// The result is always true or false
+		{`{{ "a" and 1 }}`, true},
+		{`{{ missing or "" }}`, true},
+		{"{{ missing or missing }}", false},
+	}
+
+	for i, test := range tests {
+		results := evalInput(t, test.input, context.New())
+
+		checkStatementCount(t, results, 1)
+
+		if results[0].Type() != object.TYPE_BOOL || results[0].Value() != test.expected {
+			t.Errorf("(%d) Expected %v got %#v", i, test.expected, results[0])
+		}
+	}
+}
+
+func TestLogicalOperatorsShortCircuit(t *testing.T) {
+	tests := []string{
+		`{{ false and (1 | explode) }}`,
+		`{{ true or (1 | explode) }}`,
+		`{{ false && [1][5] }}`,
+	}
+
+	for i, input := range tests {
+		ctx := context.New()
+		evalInput(t, input, ctx)
+
+		if len(ctx.Errors()) > 0 {
+			t.Errorf("(%d) Evaluated the right side, got %s", i, ctx.Errors()[0])
+		}
+	}
+
+	// When it's needed, the right side is evaluated and errors are reported
+	ctx := context.New()
+	evalInput(t, `{{ true and (1 | explode) }}`, ctx)
+
+	if len(ctx.Errors()) != 1 {
+		t.Errorf("Expected the right side to error, got %d errors", len(ctx.Errors()))
+	}
+}
+
 func TestStrings(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -78,7 +303,7 @@ func TestStrings(t *testing.T) {
 		{`{{ "A string" }}`, "A string"},
 		{`{{ 'Single Quotes' }}`, "Single Quotes"},
 		{`{{ "Mixe'd Quotes" }}`, "Mixe'd Quotes"},
-		{`{{ 'Escape\'d Quotes' }}`, "Escape\\'d Quotes"},
+		{`{{ 'Escape\'d Quotes' }}`, "Escape'd Quotes"},
 	}
 
 	for _, test := range tests {
@@ -163,6 +388,47 @@ func TestArrayAccess(t *testing.T) {
 	}
 }
 
+func TestRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{{ (1..5) }}`, "1..5"},
+		{`{{ (n..n * 2) }}`, "3..6"},
+		{`{{ (1..5) == (1..5) }}`, "true"},
+		{`{{ (1..5) == (1..4) }}`, "false"},
+
+		// Slicing includes both ends, ignoring anything out of bounds
+		{`{{ [1, 2, 3, 4][1..2] }}`, "[2,3]"},
+		{`{{ [1, 2, 3, 4][2..10] }}`, "[3,4]"},
+		{`{{ [1, 2, 3, 4][-5..0] }}`, "[1]"},
+		{`{{ [1, 2, 3, 4][3..1] }}`, "[]"},
+		{`{{ [1, 2, 3, 4][5..6] }}`, "[]"},
+		{`{{ "Hello"[1..3] }}`, "ell"},
+		{`{{ "Crème brûlée"[6..11] }}`, "brûlée"},
+		{`{{ "Hi"[5..9] }}`, ""},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		ctx.Set("n", 3)
+
+		results := evalInput(t, test.input, ctx)
+		checkStatementCount(t, results, 1)
+
+		if results[0].Inspect() != test.expected {
+			t.Errorf("(%d) Expected %s got %s", i, test.expected, results[0].Inspect())
+		}
+	}
+
+	// Slices are copies
+	ctx := context.New()
+	results := evalInput(t, `{% assign list = [1, 2, 3] %}{% assign part = list[0..1] %}{{ list[0..0] }}{{ list }}`, ctx)
+	if results[len(results)-1].Inspect() != "[1,2,3]" {
+		t.Errorf("Slicing changed the original array, got %s", results[len(results)-1].Inspect())
+	}
+}
+
 func TestHashAccess(t *testing.T) {
 	tests := []string{
 		`{{ site.root.title }}`,
@@ -183,6 +449,42 @@ func TestHashAccess(t *testing.T) {
 	}
 }
 
+func TestHashLiterals(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType object.ObjectType
+		expected     interface{}
+	}{
+		{`{{ {a: 1}.a }}`, object.TYPE_NUMBER, float64(1)},
+		{`{{ {a: 1}["b"] }}`, object.TYPE_NULL, nil},
+		{`{{ {"a b": 1 + 2}["a b"] }}`, object.TYPE_NUMBER, float64(3)},
+		{`{{ {a: 1, a: 2}.a }}`, object.TYPE_NUMBER, float64(2)},
+		{`{{ {user: {name: "Jane"}}.user.name }}`, object.TYPE_STRING, "Jane"},
+		{`{{ {list: [1, 2, 3],}.list[2] }}`, object.TYPE_NUMBER, float64(3)},
+		{`{{ {name: name | upcase}.name }}`, object.TYPE_STRING, "JANE"},
+		{`{% assign h = {"a": {"b": "c"}} %}{{ h.a.b }}`, object.TYPE_STRING, "c"},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		ctx.Set("name", "Jane")
+
+		results := evalInput(t, test.input, ctx)
+		last := results[len(results)-1]
+
+		if last.Type() != test.expectedType || last.Value() != test.expected {
+			t.Errorf("(%d) Expected %v got %#v", i, test.expected, last)
+		}
+	}
+
+	results := evalInput(t, `{{ {} }}`, context.New())
+	checkStatementCount(t, results, 1)
+
+	if results[0].Type() != object.TYPE_HASH {
+		t.Fatalf("Did not get a hash. Got %T", results[0])
+	}
+}
+
 func TestFilters(t *testing.T) {
 	tests := []struct {
 		input        string
@@ -190,6 +492,7 @@ func TestFilters(t *testing.T) {
 		expected     interface{}
 	}{
 		{`{{ "A String" | size }}`, object.TYPE_NUMBER, float64(8)},
+		{`{{ "héllo" | size }}`, object.TYPE_NUMBER, float64(5)},
 		{`{{ "A String" | upcase }}`, object.TYPE_STRING, "A STRING"},
 		{`{{ "Hello Mom" | replace: "Mom", with: "World" }}`, object.TYPE_STRING, "Hello World"},
 		{`{{ "Hello Mom" | replace: " Mom", with: "" | upcase }}`, object.TYPE_STRING, "HELLO"},
@@ -288,9 +591,114 @@ func TestNestingBlockTags(t *testing.T) {
 	}
 }
 
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		errorStr string
+	}{
+		{`{{ "A String" | explode }}`, "(1:17) Unknown filter 'explode'"},
+		{`{{ "one" - 2 }}`, "(1:10) Unknown operation: STRING - NUMBER"},
+		{`{{ "one" * "two" }}`, "(1:10) Unknown operation: STRING * STRING"},
+		{`{{ true + "one" }}`, "(1:9) Unknown operation: BOOLEAN + STRING"},
+		{`{{ 1 / 0 }}`, "(1:6) Cannot divide 1 by zero"},
+		{`{{ 5 % 0 }}`, "(1:6) Cannot divide 5 by zero"},
+		{`{{ -"one" }}`, "(1:4) Unknown operation: -STRING"},
+		{`{{ [1, 2]["one"] }}`, "(1:10) Arrays can only be indexed by numbers, got STRING"},
+		{`{{ true.size }}`, "(1:8) Cannot index into a value of type BOOLEAN"},
+		{`{{ (1..1.5) }}`, "(1:6) Ranges can only be made of whole numbers, got 1.5"},
+		{`{{ ("a"..2) }}`, "(1:8) Ranges can only be made of whole numbers, got STRING"},
+		{`{{ 1..nope }}`, "(1:5) Ranges can only be made of whole numbers, got NULL"},
+		{`{% for a, b in [1] %}{% end %}`, "(1:4) Only a Hash can be looped over with two variables, got ARRAY"},
+		{`{% for a, b, c in {} %}{% end %}`, "(1:4) Expected one or two loop variables, got 3"},
+		{`{% for a in [1] limit: "2" %}{% end %}`, "(1:4) The for loop's limit must be a whole number, got 2"},
+		{`{% for a in [1] offset: 1.5 %}{% end %}`, "(1:4) The for loop's offset must be a whole number, got 1.5"},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		results := evalInput(t, test.input, ctx)
+
+		if !object.IsError(results[0]) {
+			t.Fatalf("(%d) Expected an error object, got %#v", i, results[0])
+		}
+
+		errors := ctx.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("(%d) Wrong number of errors. Expected 1 got %d", i, len(errors))
+		}
+
+		if errors[0].Error() != test.errorStr {
+			t.Errorf("(%d) Wrong error. Expected `%s` got `%s`", i, test.errorStr, errors[0])
+		}
+
+		if errors[0].Snippet != test.input {
+			t.Errorf("(%d) Wrong snippet. Got `%s`", i, errors[0].Snippet)
+		}
+	}
+
+	// Digging into values that don't exist is not an error
+	ctx := context.New()
+	evalInput(t, `{{ user.name }}`, ctx)
+
+	if len(ctx.Errors()) != 0 {
+		t.Errorf("Expected no errors, got %v", ctx.Errors())
+	}
+}
+
+func TestRuntimeErrorsDoNotPanic(t *testing.T) {
+	tests := []struct {
+		input    string
+		errorStr string
+	}{
+		{`{% for x in 5 %}{{ x }}{% end %}`, "(1:4) Cannot loop over a value of type NUMBER"},
+		{`{% include 5 %}`, "(1:4) Partial names must be strings, got NUMBER"},
+		{`{{ 5 | replace: "a", with: "b" }}`, "(1:8) replace: input must be a string, got NUMBER"},
+
+		// Errors are only reported once as they make their way up the tree
+		{`{{ ("a" | nope) + 1 | upcase }}`, "(1:11) Unknown filter 'nope'"},
+		{`{% assign x = [1, "a" | nope] %}`, "(1:25) Unknown filter 'nope'"},
+		{`{% if "a" | nope %}Yes{% else %}No{% end %}`, "(1:13) Unknown filter 'nope'"},
+	}
+
+	for i, test := range tests {
+		ctx := context.New()
+		evalInput(t, test.input, ctx)
+
+		errors := ctx.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("(%d) Wrong number of errors. Expected 1 got %d: %v", i, len(errors), errors)
+		}
+
+		if errors[0].Error() != test.errorStr {
+			t.Errorf("(%d) Wrong error. Expected `%s` got `%s`", i, test.errorStr, errors[0])
+		}
+	}
+}
+
+func TestPanicsInFiltersAndTagsBecomeErrors(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	engine.AddFilter("explode", func(_ object.Object, _ filter.Parameters) object.Object {
+		panic("boom")
+	})
+
+	ctx := context.New()
+	l := lexer.New(`Before {{ 1 | explode }} After`)
+	p := parser.New(l, engine)
+	results := New(p.Parse(), ctx, engine).Run()
+
+	if len(results) != 3 || !object.IsError(results[1]) {
+		t.Fatalf("Expected the filter to return an error, got %v", results)
+	}
+
+	errors := ctx.Errors()
+	if len(errors) != 1 || errors[0].Error() != "(1:15) Filter 'explode' crashed: boom" {
+		t.Errorf("Wrong error, got %v", errors)
+	}
+}
+
 func evalInput(t *testing.T, input string, ctx *context.Context) []object.Object {
 	l := lexer.New(input)
-	p := parser.New(l)
+	p := parser.New(l, late.DefaultEngine())
 	tpl := p.Parse()
 
 	if len(p.Errors) > 0 {
@@ -301,7 +709,7 @@ func evalInput(t *testing.T, input string, ctx *context.Context) []object.Object
 		t.FailNow()
 	}
 
-	e := New(tpl, ctx)
+	e := New(tpl, ctx, late.DefaultEngine())
 	return e.Run()
 }
 
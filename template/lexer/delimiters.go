@@ -0,0 +1,179 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/template/token"
+)
+
+/**
+ * DelimiterSet is the set of open/close pairs that mark where Late code
+ * starts and ends in a template. Change these for templates where the
+ * defaults collide with the content, e.g. LaTeX or Vue.
+ *
+ * Raw delimiters are checked before the others, so the raw delimiters may
+ * start with the variable delimiters, as `{{{` does with `{{`.
+ * Empty fields fall back to the defaults.
+ */
+type DelimiterSet struct {
+	OpenVar  string
+	CloseVar string
+
+	OpenTag  string
+	CloseTag string
+
+	OpenRaw  string
+	CloseRaw string
+
+	OpenComment  string
+	CloseComment string
+}
+
+var DefaultDelimiters = DelimiterSet{
+	OpenVar:      "{{",
+	CloseVar:     "}}",
+	OpenTag:      "{%",
+	CloseTag:     "%}",
+	OpenRaw:      "{{{",
+	CloseRaw:     "}}}",
+	OpenComment:  "{#",
+	CloseComment: "#}",
+}
+
+// Delimiters sets the delimiters the lexer starts out with.
+// A delimiters pragma in the template itself takes precedence.
+// A set with conflicting delimiters is an error, and the defaults are used instead.
+func Delimiters(set DelimiterSet) func(*Lexer) {
+	return func(l *Lexer) {
+		l.delimiters = set.withDefaults()
+	}
+}
+
+func (d DelimiterSet) withDefaults() DelimiterSet {
+	fill := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+
+	fill(&d.OpenVar, DefaultDelimiters.OpenVar)
+	fill(&d.CloseVar, DefaultDelimiters.CloseVar)
+	fill(&d.OpenTag, DefaultDelimiters.OpenTag)
+	fill(&d.CloseTag, DefaultDelimiters.CloseTag)
+	fill(&d.OpenRaw, DefaultDelimiters.OpenRaw)
+	fill(&d.CloseRaw, DefaultDelimiters.CloseRaw)
+	fill(&d.OpenComment, DefaultDelimiters.OpenComment)
+	fill(&d.CloseComment, DefaultDelimiters.CloseComment)
+
+	return d
+}
+
+// The lexer looks for delimiters in this order, so none of them can
+// start with one that comes before it.
+func (d DelimiterSet) conflict() string {
+	ordered := []struct{ name, value string }{
+		{"raw open", d.OpenRaw},
+		{"raw close", d.CloseRaw},
+		{"comment open", d.OpenComment},
+		{"comment close", d.CloseComment},
+		{"tag open", d.OpenTag},
+		{"tag close", d.CloseTag},
+		{"var open", d.OpenVar},
+		{"var close", d.CloseVar},
+	}
+
+	for i, earlier := range ordered {
+		for _, later := range ordered[i+1:] {
+			if strings.HasPrefix(later.value, earlier.value) {
+				return fmt.Sprintf("The %s delimiter '%s' conflicts with the %s delimiter '%s'",
+					earlier.name, earlier.value, later.name, later.value)
+			}
+		}
+	}
+
+	return ""
+}
+
+// The open and close fields for each kind of delimiter a pragma can set
+func (d *DelimiterSet) pair(kind string) (*string, *string) {
+	switch kind {
+	case "var":
+		return &d.OpenVar, &d.CloseVar
+	case "tag":
+		return &d.OpenTag, &d.CloseTag
+	case "raw":
+		return &d.OpenRaw, &d.CloseRaw
+	case "comment":
+		return &d.OpenComment, &d.CloseComment
+	}
+
+	return nil, nil
+}
+
+/**
+ * A template can choose its own delimiters with a comment at its very start,
+ * listing the kind of delimiter followed by its open and close pair:
+ *
+ *   {# delimiters var [[ ]] tag [% %] #}
+ *
+ * Kinds not listed keep their current delimiters. The pragma, and the newline
+ * following it, produce no tokens.
+ */
+func (l *Lexer) readPragma() {
+	open, close := l.delimiters.OpenComment, l.delimiters.CloseComment
+
+	if !strings.HasPrefix(l.input, open) {
+		return
+	}
+
+	end := strings.Index(l.input[len(open):], close)
+	if end < 0 {
+		return
+	}
+
+	end += len(open)
+	fields := strings.Fields(l.input[len(open):end])
+
+	if len(fields) == 0 || fields[0] != "delimiters" {
+		return
+	}
+
+	end += len(close)
+	if strings.HasPrefix(l.input[end:], "\r\n") {
+		end += 2
+	} else if strings.HasPrefix(l.input[end:], "\n") {
+		end += 1
+	}
+
+	l.lookPosition = end
+	pragma := l.manualToken(token.RAW, strings.TrimSpace(l.input[:end]))
+
+	set := l.delimiters
+	fields = fields[1:]
+
+	for len(fields) > 0 {
+		openField, closeField := set.pair(fields[0])
+
+		if openField == nil {
+			l.Errors = append(l.Errors, errors.New(errors.InvalidDelimiters, pragma, "Unknown kind of delimiter '%s'", fields[0]))
+			return
+		}
+
+		if len(fields) < 3 {
+			l.Errors = append(l.Errors, errors.New(errors.InvalidDelimiters, pragma, "Expected an open and close delimiter for '%s'", fields[0]))
+			return
+		}
+
+		*openField, *closeField = fields[1], fields[2]
+		fields = fields[3:]
+	}
+
+	if conflict := set.conflict(); conflict != "" {
+		l.Errors = append(l.Errors, errors.New(errors.InvalidDelimiters, pragma, "%s", conflict))
+		return
+	}
+
+	l.delimiters = set
+}
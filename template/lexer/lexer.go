@@ -1,6 +1,12 @@
 package lexer
 
 import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/template/token"
 )
 
@@ -8,9 +14,19 @@ type Lexer struct {
 	input       string
 	eofPosition int
 
+	// Problems found while tokenizing the input, e.g. unterminated strings.
+	// The offending tokens are still returned so the parser can continue.
+	Errors []*errors.Error
+
 	tokenStart   int
 	lookPosition int
 
+	// What marks the start and end of Late code. See delimiters.go.
+	delimiters DelimiterSet
+
+	// Whether the named tag never outputs anything itself. See SilentTags.
+	silentTag func(string) bool
+
 	// Are we parsing actual Late?
 	// For anything outside of Late tags, we want to combine all text
 	// into a single Raw token that can be trivially included back in the
@@ -23,6 +39,18 @@ type Lexer struct {
 	// to just parse everything as a single RAW token between the delimiters.
 	nextTokenEndsAt string
 
+	// How many hash literal braces are currently open. While we're inside
+	// a hash, `}}` closes the hash(es) and not the surrounding {{ }}, e.g.
+	//
+	//   {% assign user = {"name": {"first": "Jane"}} %}
+	//
+	braceDepth int
+
+	// Whitespace in the template's text that is left out of RAW token
+	// literals, from trim markers and the automatic trimming around tags.
+	// See whitespace.go. Nil if nothing is dropped.
+	dropped []bool
+
 	// Keep track of location positioning so we can properly tag each token
 	// with the correct line and char position.
 	// These values are 1-based
@@ -30,9 +58,38 @@ type Lexer struct {
 	currentChar int
 }
 
-func New(input string) *Lexer {
+func New(input string, options ...func(*Lexer)) *Lexer {
+	l := newLexer(input)
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	if conflict := l.delimiters.conflict(); conflict != "" {
+		l.Errors = append(l.Errors, errors.New(errors.InvalidDelimiters, token.Token{Line: 1, Char: 1}, "%s", conflict))
+		l.delimiters = DefaultDelimiters
+	}
+
+	l.readPragma()
+	l.dropped = droppedWhitespace(l)
+
+	return l
+}
+
+// SilentTags tells the lexer which tags never output anything themselves.
+// Lines holding nothing but silent tags are left out of the output, newline
+// included. Without this option every tag is taken to be silent.
+func SilentTags(isSilent func(name string) bool) func(*Lexer) {
+	return func(l *Lexer) {
+		l.silentTag = isSilent
+	}
+}
+
+// A lexer that leaves all whitespace in place
+func newLexer(input string) *Lexer {
 	return &Lexer{
 		input:        input,
+		delimiters:   DefaultDelimiters,
 		eofPosition:  len(input) - 1,
 		tokenStart:   0,
 		lookPosition: 0,
@@ -41,6 +98,11 @@ func New(input string) *Lexer {
 	}
 }
 
+// Input returns the full text this lexer is tokenizing
+func (l *Lexer) Input() string {
+	return l.input
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
@@ -80,30 +142,39 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 		return l.eofToken()
 	}
 
+	if l.braceDepth > 0 && l.peek() == '}' {
+		l.braceDepth -= 1
+		return l.charToken(token.RBRACKET)
+	}
+
+	d := l.delimiters
+
+	// Opening and closing delimiters can have a `-` trim marker right
+	// next to them, e.g. {%- and -%}. See whitespace.go.
 	switch {
-	case l.test("{%end%}"):
+	case l.testEnd(d.OpenTag, d.CloseTag):
 		l.inCode = false
 		tok = l.stringToken(token.END)
-	case l.test("{{{"):
+	case l.testExact(d.OpenRaw + "-"), l.testExact(d.OpenRaw):
 		tok = l.stringToken(token.OPEN_RAW)
-		l.nextTokenEndsAt = "}}}"
-	case l.test("}}}"):
+		l.nextTokenEndsAt = d.CloseRaw
+	case l.testExact("-" + d.CloseRaw), l.testExact(d.CloseRaw):
 		l.inCode = false
 		tok = l.stringToken(token.CLOSE_RAW)
-	case l.test("{#"):
+	case l.testExact(d.OpenComment + "-"), l.test(d.OpenComment):
 		tok = l.stringToken(token.OPEN_COMMENT)
-		l.nextTokenEndsAt = "#}"
-	case l.test("#}"):
+		l.nextTokenEndsAt = d.CloseComment
+	case l.testExact("-" + d.CloseComment), l.testExact(d.CloseComment):
 		l.inCode = false
 		tok = l.stringToken(token.CLOSE_COMMENT)
-	case l.test("{%"):
+	case l.testExact(d.OpenTag + "-"), l.test(d.OpenTag):
 		tok = l.stringToken(token.OPEN_TAG)
-	case l.test("%}"):
+	case l.testExact("-" + d.CloseTag), l.testExact(d.CloseTag):
 		l.inCode = false
 		tok = l.stringToken(token.CLOSE_TAG)
-	case l.test("{{"):
+	case l.testExact(d.OpenVar + "-"), l.test(d.OpenVar):
 		tok = l.stringToken(token.OPEN_VAR)
-	case l.test("}}"):
+	case l.testExact("-" + d.CloseVar), l.testExact(d.CloseVar):
 		l.inCode = false
 		tok = l.stringToken(token.CLOSE_VAR)
 	case l.test(">="):
@@ -114,6 +185,14 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 		tok = l.stringToken(token.EQ)
 	case l.test("!="):
 		tok = l.stringToken(token.NOT_EQ)
+	case l.test("&&"):
+		tok = l.stringToken(token.AND)
+	case l.test("||"):
+		tok = l.stringToken(token.OR)
+	case l.test("//"):
+		tok = l.stringToken(token.INT_DIV)
+	case l.testExact(".."):
+		tok = l.stringToken(token.RANGE)
 	}
 
 	if tok.Type != "" {
@@ -122,6 +201,7 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 
 	switch l.peek() {
 	case '{':
+		l.braceDepth += 1
 		tok = l.charToken(token.LBRACKET)
 	case '}':
 		tok = l.charToken(token.RBRACKET)
@@ -145,6 +225,8 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 		tok = l.charToken(token.TIMES)
 	case '/':
 		tok = l.charToken(token.SLASH)
+	case '%':
+		tok = l.charToken(token.PERCENT)
 	case '(':
 		tok = l.charToken(token.LPAREN)
 	case ')':
@@ -155,8 +237,20 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 		tok = l.charToken(token.LT)
 	case '=':
 		tok = l.charToken(token.ASSIGN)
+	case '!':
+		tok = l.charToken(token.NOT)
 	case '"', '\'':
-		tok = l.manualToken(token.STRING, l.readString())
+		literal, terminated, escapeStart, escapeEnd := l.readString()
+		tok = l.manualToken(token.STRING, literal)
+
+		if !terminated {
+			l.Errors = append(l.Errors, errors.New(errors.UnterminatedString, tok, "Unterminated string"))
+		}
+
+		if escapeStart < escapeEnd {
+			escape := l.innerToken(tok, escapeStart, escapeEnd)
+			l.Errors = append(l.Errors, errors.New(errors.InvalidEscape, escape, "Invalid escape sequence '%s'", escape.Raw))
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -164,7 +258,7 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 		if isNumber(l.peek()) {
 			tok = l.manualToken(token.NUMBER, l.readNumber())
 			return
-		} else if isIdentifier(l.peek()) {
+		} else if isIdentifier(l.peekRune()) {
 			tok = l.manualToken(token.IDENT, l.readIdentifier())
 
 			switch tok.Literal {
@@ -172,10 +266,23 @@ func (l *Lexer) parseNextCodeToken() (tok token.Token) {
 				tok.Type = token.TRUE
 			case "false":
 				tok.Type = token.FALSE
+			case "null", "nil":
+				tok.Type = token.NULL
+			case "and":
+				tok.Type = token.AND
+			case "or":
+				tok.Type = token.OR
+			case "not":
+				tok.Type = token.NOT
+			case "contains":
+				tok.Type = token.CONTAINS
 			}
 			return
 		} else {
-			tok = l.charToken(token.ILLEGAL)
+			_, size := utf8.DecodeRuneInString(l.input[l.lookPosition:])
+			l.lookPosition += size
+			tok = l.stringToken(token.ILLEGAL)
+			l.Errors = append(l.Errors, errors.New(errors.IllegalCharacter, tok, "Illegal character '%s'", tok.Literal))
 		}
 	}
 
@@ -189,6 +296,12 @@ func (l *Lexer) parseUntilTokenEnd() token.Token {
 		if l.lookPosition > tokenEndLen {
 			if l.input[l.lookPosition-tokenEndLen:l.lookPosition] == l.nextTokenEndsAt {
 				l.lookPosition -= tokenEndLen
+
+				// Leave a trim marker for the closing token
+				if l.lookPosition > l.tokenStart && l.input[l.lookPosition-1] == '-' {
+					l.lookPosition -= 1
+				}
+
 				break
 			}
 		}
@@ -200,7 +313,14 @@ func (l *Lexer) parseUntilTokenEnd() token.Token {
 		l.step()
 	}
 
-	return l.stringToken(token.RAW)
+	tok := l.stringToken(token.RAW)
+
+	// Verbatim content is output without its surrounding whitespace
+	if l.nextTokenEndsAt == l.delimiters.CloseRaw {
+		tok.Literal = strings.TrimSpace(tok.Literal)
+	}
+
+	return tok
 }
 
 func (l *Lexer) parseUntilCode() token.Token {
@@ -236,6 +356,25 @@ func (l *Lexer) test(expect string) bool {
 	return true
 }
 
+// {% end %}, with or without trim markers
+func (l *Lexer) testEnd(open, close string) bool {
+	return l.test(open+"end"+close) ||
+		l.test(open+"-end"+close) ||
+		l.test(open+"end-"+close) ||
+		l.test(open+"-end-"+close)
+}
+
+// Like test, but the characters must be next to each other, so that
+// `{{ {` opens a hash literal and not a raw block.
+func (l *Lexer) testExact(expect string) bool {
+	if l.lookPosition+len(expect) > len(l.input) || l.input[l.lookPosition:l.lookPosition+len(expect)] != expect {
+		return false
+	}
+
+	l.lookPosition += len(expect)
+	return true
+}
+
 func (l *Lexer) skipWhitespace() {
 	for !l.atEOF() && l.isWhitespace(l.peek()) {
 		l.step()
@@ -253,73 +392,139 @@ func (l *Lexer) atEOF() bool {
 func (l *Lexer) atCodeStart() bool {
 	// Manual checking our characters from the input string here
 	// as we don't want to step the lookPosition
-	if l.lookPosition == l.eofPosition {
-		return false
-	}
-
-	at := l.input[l.lookPosition]
-	peek := l.input[l.lookPosition+1]
+	rest := l.input[l.lookPosition:]
+	d := l.delimiters
 
-	return at == '{' && (peek == '{' || peek == '%' || peek == '#')
+	return strings.HasPrefix(rest, d.OpenVar) ||
+		strings.HasPrefix(rest, d.OpenTag) ||
+		strings.HasPrefix(rest, d.OpenRaw) ||
+		strings.HasPrefix(rest, d.OpenComment)
 }
 
-func (l *Lexer) readString() string {
+// Returns the content of the string, with escape sequences applied,
+// whether or not we found the closing quote before hitting the end of the input,
+// and where the first escape sequence we didn't understand starts and ends, if any.
+func (l *Lexer) readString() (string, bool, int, int) {
 	// Keep track of what character opened our string (' or ")
-	// so we can find our matching closing quote,
-	// making sure that we look for escaped quotes and don't prematurely
-	// end our string parsing.
-	prev := l.peek()
+	// so we can find our matching closing quote.
 	openWith := l.peek()
 	var buffer []byte
+	var badStart, badEnd int
 	l.step()
 
-	for {
-		if (l.peek() == openWith && prev != '\\') || l.atEOF() {
-			break
+	for !l.atEOF() && l.peek() != openWith {
+		if l.peek() != '\\' {
+			buffer = append(buffer, l.peek())
+			l.step()
+			continue
 		}
 
-		prev = l.peek()
-		buffer = append(buffer, l.peek())
-		l.step()
+		var ok bool
+		escapeStart := l.lookPosition
+
+		if buffer, ok = l.readEscape(buffer); !ok && badEnd == 0 {
+			badStart, badEnd = escapeStart, l.lookPosition
+		}
+	}
+
+	if l.atEOF() {
+		return string(buffer), false, badStart, badEnd
 	}
 
 	// And finally move onto the closing quote
 	l.step()
 
-	return string(buffer)
+	return string(buffer), true, badStart, badEnd
+}
+
+/**
+ * Escape sequences are those of JSON and Go:
+ *
+ *   \n \t \r \\ \" \'     newline, tab, carriage return, backslash and quotes
+ *   \u00e9                 any unicode character, by its four digit hex code
+ *   \U0001f600             or by its eight digit hex code
+ *
+ * Anything else is kept as written, and reported as invalid.
+ */
+func (l *Lexer) readEscape(buffer []byte) ([]byte, bool) {
+	// Skip the backslash
+	l.step()
+
+	if l.atEOF() {
+		return append(buffer, '\\'), false
+	}
+
+	escaped := l.peek()
+	l.step()
+
+	switch escaped {
+	case 'n':
+		return append(buffer, '\n'), true
+	case 't':
+		return append(buffer, '\t'), true
+	case 'r':
+		return append(buffer, '\r'), true
+	case '\\', '"', '\'':
+		return append(buffer, escaped), true
+	case 'u', 'U':
+		digits := 4
+		if escaped == 'U' {
+			digits = 8
+		}
+
+		end := l.lookPosition + digits
+		if end <= len(l.input) {
+			code, err := strconv.ParseUint(l.input[l.lookPosition:end], 16, 32)
+
+			if err == nil && utf8.ValidRune(rune(code)) {
+				l.lookPosition = end
+				return append(buffer, string(rune(code))...), true
+			}
+		}
+	}
+
+	return append(buffer, '\\', escaped), false
 }
 
 func (l *Lexer) readNumber() string {
 	// We don't currently support starting a float number
 	// without a leading number, e.g. don't support ".1234".
-	notFirst := false
-	var buffer []byte
+	// A dot only continues the number when a digit follows it, so that
+	// ranges like 1..5 are three tokens.
+	start := l.lookPosition
+	seenDot := false
 
-	for isNumber(l.peek()) || (notFirst && l.peek() == '.') {
-		buffer = append(buffer, l.peek())
-		l.step()
-		notFirst = true
+	for !l.atEOF() {
+		if isNumber(l.peek()) {
+			l.step()
+		} else if l.peek() == '.' && !seenDot && l.lookPosition+1 < len(l.input) && isNumber(l.input[l.lookPosition+1]) {
+			seenDot = true
+			l.step()
+		} else {
+			break
+		}
 	}
 
-	return string(buffer)
+	return l.input[start:l.lookPosition]
 }
 
 func (l *Lexer) readIdentifier() string {
-	var buffer []byte
+	start := l.lookPosition
 
-	for isIdentifier(l.peek()) {
-		buffer = append(buffer, l.peek())
-		l.step()
+	for isIdentifier(l.peekRune()) {
+		_, size := utf8.DecodeRuneInString(l.input[l.lookPosition:])
+		l.lookPosition += size
 	}
 
-	return string(buffer)
+	return l.input[start:l.lookPosition]
 }
 
 func (l *Lexer) eofToken() token.Token {
 	return token.Token{
-		Type: token.EOF,
-		Line: l.currentLine,
-		Char: l.currentChar,
+		Type:   token.EOF,
+		Line:   l.currentLine,
+		Char:   l.currentChar,
+		Offset: l.lookPosition,
 	}
 }
 
@@ -350,7 +555,7 @@ func (l *Lexer) stringToken(t token.TokenType) token.Token {
 	tok := l.manualToken(t, string(buffer))
 
 	if tok.Type == token.RAW {
-		tok.Literal = tok.Raw
+		tok.Literal = l.keptText(l.tokenStart, l.lookPosition)
 	}
 
 	return tok
@@ -376,6 +581,7 @@ func (l *Lexer) manualToken(t token.TokenType, literal string) token.Token {
 	if offset == len(raw) {
 		l.currentLine = lineWas
 		l.currentChar = charWas
+		offset = 0
 	}
 
 	tok := token.Token{
@@ -384,6 +590,7 @@ func (l *Lexer) manualToken(t token.TokenType, literal string) token.Token {
 		Raw:     raw,
 		Line:    l.currentLine,
 		Char:    l.currentChar,
+		Offset:  l.tokenStart + offset,
 	}
 
 	l.currentLine = lineWas
@@ -392,6 +599,30 @@ func (l *Lexer) manualToken(t token.TokenType, literal string) token.Token {
 	return tok
 }
 
+// Build a token for input[start:end], a piece of the given token, so errors
+// can point inside of it, e.g. at one escape sequence of a string.
+func (l *Lexer) innerToken(tok token.Token, start, end int) token.Token {
+	inner := token.Token{
+		Type:    tok.Type,
+		Literal: l.input[start:end],
+		Raw:     l.input[start:end],
+		Line:    tok.Line,
+		Char:    tok.Char,
+		Offset:  start,
+	}
+
+	for i := tok.Offset; i < start; i++ {
+		if l.input[i] == '\n' {
+			inner.Line += 1
+			inner.Char = 1
+		} else if utf8.RuneStart(l.input[i]) {
+			inner.Char += 1
+		}
+	}
+
+	return inner
+}
+
 /**
  * The start of our next token lives at lookPosition, so we need to move our
  * tokenStart pointer to that new location and prepare for our next step.
@@ -404,11 +635,13 @@ func (l *Lexer) startNextToken() {
 	}
 }
 
+// Columns count characters, not bytes, to match what editors show.
+// Only the first byte of each UTF-8 encoded character moves us forward.
 func (l *Lexer) stepLocationInfo(ch byte) {
 	if ch == '\n' {
 		l.currentLine += 1
 		l.currentChar = 1
-	} else {
+	} else if utf8.RuneStart(ch) {
 		l.currentChar += 1
 	}
 }
@@ -417,6 +650,16 @@ func (l *Lexer) peek() byte {
 	return l.input[l.lookPosition]
 }
 
+// The full character at the look position, which may be more than one byte
+func (l *Lexer) peekRune() rune {
+	if l.atEOF() {
+		return 0
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.input[l.lookPosition:])
+	return r
+}
+
 func (l *Lexer) step() {
 	l.lookPosition += 1
 }
@@ -425,9 +668,10 @@ func isNumber(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isIdentifier(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' ||
-		'A' <= ch && ch <= 'Z' ||
-		'0' <= ch && ch <= '9' ||
-		ch == '_'
+// Identifiers can be written in any language, e.g. {{ prénom }} or {{ 名前 }}
+func isIdentifier(r rune) bool {
+	return unicode.IsLetter(r) ||
+		unicode.IsDigit(r) ||
+		unicode.IsMark(r) ||
+		r == '_'
 }
@@ -39,13 +39,13 @@ func TestTokenizesInput(t *testing.T) {
 		{token.DOT, "."},
 		{token.IDENT, "method"},
 		{token.CLOSE_VAR, "}}"}, // 5
-		{token.RAW, "\n\t\t"},
+		{token.RAW, "\n"},
 		{token.OPEN_TAG, "{%"},
 		{token.IDENT, "tag"},
 		{token.CLOSE_TAG, "%}"},
-		{token.RAW, "\n\t\t\tStuff here\n\t\t"}, // 10
+		{token.RAW, "\t\tStuff here\n"}, // 10
 		{token.END, "{%end%}"},
-		{token.RAW, "\n\t\tSo much { Not % quite { { code } % } here.\n\t\t\"This is stringy\"\n\t\t"},
+		{token.RAW, "\t\tSo much { Not % quite { { code } % } here.\n\t\t\"This is stringy\"\n\t\t"},
 		{token.OPEN_VAR, "{{"},
 		{token.STRING, "This is a string"},
 		{token.PIPE, "|"}, // 15
@@ -125,6 +125,106 @@ func TestOnlyCodeTemplates(t *testing.T) {
 	testTemplateGeneratesTokens(t, input, tests)
 }
 
+func TestLogicalOperators(t *testing.T) {
+	input := "{{ a and b or !c && not d || e != f }}"
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "a"},
+		{token.AND, "and"},
+		{token.IDENT, "b"},
+		{token.OR, "or"},
+		{token.NOT, "!"},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.NOT, "not"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.NOT_EQ, "!="},
+		{token.IDENT, "f"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `{{ {a: {"b": 1}} }}{%assign h = {}%}{{{ raw }}}`
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.LBRACKET, "{"},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.LBRACKET, "{"},
+		{token.STRING, "b"},
+		{token.COLON, ":"},
+		{token.NUMBER, "1"},
+		{token.RBRACKET, "}"},
+		{token.RBRACKET, "}"},
+		{token.CLOSE_VAR, "}}"},
+		{token.OPEN_TAG, "{%"},
+		{token.IDENT, "assign"},
+		{token.IDENT, "h"},
+		{token.ASSIGN, "="},
+		{token.LBRACKET, "{"},
+		{token.RBRACKET, "}"},
+		{token.CLOSE_TAG, "%}"},
+		{token.OPEN_RAW, "{{{"},
+		{token.RAW, "raw"},
+		{token.CLOSE_RAW, "}}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestRanges(t *testing.T) {
+	input := "{{ (1..n) }}{{ list[0..2.5] }}{{ 1.5 }}"
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.LPAREN, "("},
+		{token.NUMBER, "1"},
+		{token.RANGE, ".."},
+		{token.IDENT, "n"},
+		{token.RPAREN, ")"},
+		{token.CLOSE_VAR, "}}"},
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "list"},
+		{token.LSQUARE, "["},
+		{token.NUMBER, "0"},
+		{token.RANGE, ".."},
+		{token.NUMBER, "2.5"},
+		{token.RSQUARE, "]"},
+		{token.CLOSE_VAR, "}}"},
+		{token.OPEN_VAR, "{{"},
+		{token.NUMBER, "1.5"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestNullLiterals(t *testing.T) {
+	input := "{{ null nil nullable contains }}"
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.NULL, "null"},
+		{token.NULL, "nil"},
+		{token.IDENT, "nullable"},
+		{token.CONTAINS, "contains"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
 func TestCodeStrings(t *testing.T) {
 	input := `
 		{{ "This is ' a string" }}
@@ -141,19 +241,19 @@ func TestCodeStrings(t *testing.T) {
 		{token.CLOSE_VAR, "}}"},
 		{token.RAW, "\n\t\t"},
 		{token.OPEN_VAR, "{{"},
-		{token.STRING, "Single \\' quotes"},
+		{token.STRING, "Single ' quotes"},
 		{token.CLOSE_VAR, "}}"},
 		{token.RAW, "\n\t\t"},
 		{token.OPEN_VAR, "{{"},
-		{token.STRING, "Double \\\" quotes"},
+		{token.STRING, "Double \" quotes"},
 		{token.CLOSE_VAR, "}}"},
 		{token.RAW, "\n\t\t"},
 		{token.OPEN_VAR, "{{"},
-		{token.STRING, "New \\n Lines \\n here"},
+		{token.STRING, "New \n Lines \n here"},
 		{token.CLOSE_VAR, "}}"},
 		{token.RAW, "\n\t\t"},
 		{token.OPEN_VAR, "{{"},
-		{token.STRING, "Other \\t command chars"},
+		{token.STRING, "Other \t command chars"},
 		{token.CLOSE_VAR, "}}"},
 		{token.RAW, "\n\t"},
 		{token.EOF, ""},
@@ -215,11 +315,11 @@ func TestTokenRawWithWhitespace(t *testing.T) {
 		{"{{", "{{"},
 		{"1", " 1"},
 		{"}}", " }}"},
-		{"\n\t\t", "\n\t\t"},
+		{"\n", "\n\t\t"},
 		{"{%", "{%"},
 		{"assign", " assign"},
 		{"%}", " %}"},
-		{" ", " "},
+		{"", " "},
 		{"{%end%}", "{% end %}"},
 	}
 
@@ -253,15 +353,15 @@ func TestCommentsAndRaw(t *testing.T) {
 	tests := []ExpectedToken{
 		{token.RAW, "\n\t\t"},
 		{token.OPEN_RAW, "{{{"},
-		{token.RAW, " This is {{ \"Raw Liquid\" }} "},
+		{token.RAW, "This is {{ \"Raw Liquid\" }}"},
 		{token.CLOSE_RAW, "}}}"},
-		{token.RAW, "\n\t\t"},
+		{token.RAW, "\n"},
 		{token.OPEN_COMMENT, "{#"},
 		{token.RAW, " Ignore me {% {{ "},
 		{token.CLOSE_COMMENT, "#}"},
-		{token.RAW, "\n\t\t"},
+		{token.RAW, "\t\t"},
 		{token.OPEN_RAW, "{{{"},
-		{token.RAW, " Invalid {{ !Liquid {% "},
+		{token.RAW, "Invalid {{ !Liquid {%"},
 		{token.CLOSE_RAW, "}}}"},
 		{token.EOF, ""},
 	}
@@ -269,6 +369,339 @@ func TestCommentsAndRaw(t *testing.T) {
 	testTemplateGeneratesTokens(t, input, tests)
 }
 
+func TestTrimMarkers(t *testing.T) {
+	input := "A  {{- 1 -}}  B\n{%- tag -%}\n C {#- x -#} D {{{- raw -}}}\t{%- end -%} E"
+
+	tests := []ExpectedToken{
+		{token.RAW, "A"},
+		{token.OPEN_VAR, "{{-"},
+		{token.NUMBER, "1"},
+		{token.CLOSE_VAR, "-}}"},
+		{token.RAW, "B"},
+		{token.OPEN_TAG, "{%-"},
+		{token.IDENT, "tag"},
+		{token.CLOSE_TAG, "-%}"},
+		{token.RAW, "C"},
+		{token.OPEN_COMMENT, "{#-"},
+		{token.RAW, " x "},
+		{token.CLOSE_COMMENT, "-#}"},
+		{token.RAW, "D"},
+		{token.OPEN_RAW, "{{{-"},
+		{token.RAW, "raw"},
+		{token.CLOSE_RAW, "-}}}"},
+		{token.RAW, ""},
+		{token.END, "{%-end-%}"},
+		{token.RAW, "E"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestSilentTags(t *testing.T) {
+	input := "A\n{% assign x = 1 %}\n{% include x %}\nB"
+	silent := func(name string) bool { return name != "include" }
+
+	tests := []ExpectedToken{
+		{token.RAW, "A\n"},
+		{token.OPEN_TAG, "{%"},
+		{token.IDENT, "assign"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.NUMBER, "1"},
+		{token.CLOSE_TAG, "%}"},
+		{token.RAW, ""},
+		{token.OPEN_TAG, "{%"},
+		{token.IDENT, "include"},
+		{token.IDENT, "x"},
+		{token.CLOSE_TAG, "%}"},
+		{token.RAW, "\nB"},
+		{token.EOF, ""},
+	}
+
+	testLexerGeneratesTokens(t, New(input, SilentTags(silent)), tests)
+}
+
+func TestNegativeNumbersAreNotTrimMarkers(t *testing.T) {
+	input := "{{ -1 }}{{ 2 - -3 }}"
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.MINUS, "-"},
+		{token.NUMBER, "1"},
+		{token.CLOSE_VAR, "}}"},
+		{token.OPEN_VAR, "{{"},
+		{token.NUMBER, "2"},
+		{token.MINUS, "-"},
+		{token.MINUS, "-"},
+		{token.NUMBER, "3"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestCustomDelimiters(t *testing.T) {
+	input := "{{ a }} [[ b -]] <% if c %><#- d #>((( [[ e ]] )))<%end%>"
+	delimiters := DelimiterSet{
+		OpenVar:      "[[",
+		CloseVar:     "]]",
+		OpenTag:      "<%",
+		CloseTag:     "%>",
+		OpenRaw:      "(((",
+		CloseRaw:     ")))",
+		OpenComment:  "<#",
+		CloseComment: "#>",
+	}
+
+	tests := []ExpectedToken{
+		{token.RAW, "{{ a }} "},
+		{token.OPEN_VAR, "[["},
+		{token.IDENT, "b"},
+		{token.CLOSE_VAR, "-]]"},
+		{token.RAW, ""},
+		{token.OPEN_TAG, "<%"},
+		{token.IDENT, "if"},
+		{token.IDENT, "c"},
+		{token.CLOSE_TAG, "%>"},
+		{token.OPEN_COMMENT, "<#-"},
+		{token.RAW, " d "},
+		{token.CLOSE_COMMENT, "#>"},
+		{token.OPEN_RAW, "((("},
+		{token.RAW, "[[ e ]]"},
+		{token.CLOSE_RAW, ")))"},
+		{token.END, "<%end%>"},
+		{token.EOF, ""},
+	}
+
+	l := New(input, Delimiters(delimiters))
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("(%d) Wrong token, expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestDelimitersPragma(t *testing.T) {
+	input := "{# delimiters var [[ ]] tag [% %] #}\n{{ a }}\n[% tag %]\n  [[ b ]]"
+
+	tests := []ExpectedToken{
+		{token.RAW, "{{ a }}\n"},
+		{token.OPEN_TAG, "[%"},
+		{token.IDENT, "tag"},
+		{token.CLOSE_TAG, "%]"},
+		{token.RAW, ""},
+		{token.OPEN_VAR, "[["},
+		{token.IDENT, "b"},
+		{token.CLOSE_VAR, "]]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("(%d) Wrong token, expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		// Positions still count the pragma's line
+		if tt.expectedType == token.IDENT && tok.Literal == "b" && (tok.Line != 4 || tok.Char != 6) {
+			t.Errorf("(%d) Wrong position, expected 4:6 got %d:%d", i, tok.Line, tok.Char)
+		}
+	}
+
+	// The pragma uses the delimiters given to the lexer
+	l = New("<# delimiters var ${ } #>${ x }", Delimiters(DelimiterSet{OpenComment: "<#", CloseComment: "#>"}))
+	testLexerGeneratesTokens(t, l, []ExpectedToken{
+		{token.OPEN_VAR, "${"},
+		{token.IDENT, "x"},
+		{token.CLOSE_VAR, "}"},
+		{token.EOF, ""},
+	})
+
+	// Comments that aren't pragmas are left alone
+	testTemplateGeneratesTokens(t, "{# delimit #}", []ExpectedToken{
+		{token.OPEN_COMMENT, "{#"},
+		{token.RAW, " delimit "},
+		{token.CLOSE_COMMENT, "#}"},
+		{token.EOF, ""},
+	})
+}
+
+func TestCloseDelimitersMatchExactly(t *testing.T) {
+	l := New("[[ list[0] ]][[ {a: 1}]]", Delimiters(DelimiterSet{OpenVar: "[[", CloseVar: "]]"}))
+
+	testLexerGeneratesTokens(t, l, []ExpectedToken{
+		{token.OPEN_VAR, "[["},
+		{token.IDENT, "list"},
+		{token.LSQUARE, "["},
+		{token.NUMBER, "0"},
+		{token.RSQUARE, "]"},
+		{token.CLOSE_VAR, "]]"},
+		{token.OPEN_VAR, "[["},
+		{token.LBRACKET, "{"},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.NUMBER, "1"},
+		{token.RBRACKET, "}"},
+		{token.CLOSE_VAR, "]]"},
+		{token.EOF, ""},
+	})
+
+	// Spaced out close delimiters are not close delimiters
+	l = New("{{ a } } }}")
+
+	testLexerGeneratesTokens(t, l, []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "a"},
+		{token.RBRACKET, "}"},
+		{token.RBRACKET, "}"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	})
+}
+
+func TestConflictingDelimiters(t *testing.T) {
+	l := New("{{ a }}", Delimiters(DelimiterSet{OpenTag: "{{", CloseTag: "}}"}))
+
+	if len(l.Errors) != 1 {
+		t.Fatalf("Wrong number of errors. Expected 1 got %d", len(l.Errors))
+	}
+
+	expected := "(1:1) The tag open delimiter '{{' conflicts with the var open delimiter '{{'"
+	if l.Errors[0].Error() != expected {
+		t.Errorf("Wrong error. Expected `%s` got `%s`", expected, l.Errors[0])
+	}
+
+	// The defaults are used instead
+	testLexerGeneratesTokens(t, l, []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "a"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	})
+}
+
+func TestDelimitersPragmaErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		errorStr string
+	}{
+		{"{# delimiters var [[ #}", "(1:1) Expected an open and close delimiter for 'var'"},
+		{"{# delimiters output [[ ]] #}", "(1:1) Unknown kind of delimiter 'output'"},
+		{"{# delimiters var {{ }} tag {{ }} #}", "(1:1) The tag open delimiter '{{' conflicts with the var open delimiter '{{'"},
+		{"{# delimiters tag [ ] var [[ ]] #}", "(1:1) The tag open delimiter '[' conflicts with the var open delimiter '[['"},
+		{"{# delimiters var | | #}", "(1:1) The var open delimiter '|' conflicts with the var close delimiter '|'"},
+	}
+
+	for i, test := range tests {
+		l := New(test.input)
+
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		if len(l.Errors) != 1 {
+			t.Fatalf("(%d) Wrong number of errors. Expected 1 got %d", i, len(l.Errors))
+		}
+
+		if l.Errors[0].Error() != test.errorStr {
+			t.Errorf("(%d) Wrong error. Expected `%s` got `%s`", i, test.errorStr, l.Errors[0])
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"Line\nBreak"`, "Line\nBreak"},
+		{`"Tab\there"`, "Tab\there"},
+		{`"Carriage\rReturn"`, "Carriage\rReturn"},
+		{`"Back\\slash"`, "Back\\slash"},
+		{`"Say \"hi\""`, `Say "hi"`},
+		{`'It\'s'`, "It's"},
+		{`"Don\'t mind"`, "Don't mind"},
+		{`"Caf\u00e9"`, "Café"},
+		{`"\U0001F600"`, "😀"},
+		{`"Ends in \\"`, "Ends in \\"},
+		{`"Already é"`, "Already é"},
+	}
+
+	for i, test := range tests {
+		l := New("{{ " + test.input + " }}")
+		l.NextToken()
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING || tok.Literal != test.expected {
+			t.Errorf("(%d) Wrong string, expected=%q got=%s %q", i, test.expected, tok.Type, tok.Literal)
+		}
+
+		if len(l.Errors) > 0 {
+			t.Errorf("(%d) Unexpected errors: %v", i, l.Errors)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "{{ prénom | 名前 }}{{ données.été_2 }}"
+
+	tests := []ExpectedToken{
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "prénom"},
+		{token.PIPE, "|"},
+		{token.IDENT, "名前"},
+		{token.CLOSE_VAR, "}}"},
+		{token.OPEN_VAR, "{{"},
+		{token.IDENT, "données"},
+		{token.DOT, "."},
+		{token.IDENT, "été_2"},
+		{token.CLOSE_VAR, "}}"},
+		{token.EOF, ""},
+	}
+
+	testTemplateGeneratesTokens(t, input, tests)
+}
+
+func TestCharacterNumbersCountRunes(t *testing.T) {
+	input := "Ça coûte {{ prix }}\n日本語 {{ 名前 }}"
+
+	tests := []struct {
+		literal string
+		line    int
+		char    int
+	}{
+		{"Ça coûte ", 1, 1},
+		{"{{", 1, 10},
+		{"prix", 1, 13},
+		{"}}", 1, 18},
+		{"\n日本語 ", 2, 1},
+		{"{{", 2, 5},
+		{"名前", 2, 8},
+		{"}}", 2, 11},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != test.literal {
+			t.Fatalf("(%d) Wrong token returned from lexer. expected=%s got=%s", i, test.literal, tok.Literal)
+		}
+
+		if tok.Line != test.line || tok.Char != test.char {
+			t.Errorf("(%d) Wrong position on %#v, expected=%d:%d got=%d:%d", i, test.literal, test.line, test.char, tok.Line, tok.Char)
+		}
+	}
+}
+
 func TestLineAndCharacterNumbers(t *testing.T) {
 	input := `First
 		{{ "Second" }}
@@ -315,8 +748,73 @@ func TestLineAndCharacterNumbers(t *testing.T) {
 	}
 }
 
+func TestByteOffsets(t *testing.T) {
+	input := "Hi\n  {{ \"there\" }}"
+
+	tests := []struct {
+		literal string
+		offset  int
+	}{
+		{"Hi\n  ", 0},
+		{"{{", 5},
+		{"there", 8},
+		{"}}", 16},
+		{"", 18},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != test.literal {
+			t.Fatalf("(%d) Wrong token returned from lexer. expected=%s got=%s", i, test.literal, tok.Literal)
+		}
+
+		if tok.Offset != test.offset {
+			t.Fatalf("(%d) Wrong offset on %#v, expected=%d got=%d", i, test.literal, test.offset, tok.Offset)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		errorStr string
+	}{
+		{`{{ "Never ends }}`, "(1:4) Unterminated string"},
+		{`{{ 1 @ 2 }}`, "(1:6) Illegal character '@'"},
+		{`{{ 1 → 2 }}`, "(1:6) Illegal character '→'"},
+		{`{{ "Bad \q escape" }}`, `(1:9) Invalid escape sequence '\q'`},
+		{`{{ "Bad \u00zz escape" }}`, `(1:9) Invalid escape sequence '\u'`},
+		{`{{ "Bad \U00110000 escape" }}`, `(1:9) Invalid escape sequence '\U'`},
+		{`{{ "Short \u12" }}`, `(1:11) Invalid escape sequence '\u'`},
+		{`{{ "Où \x" }}`, `(1:8) Invalid escape sequence '\x'`},
+		{"{{ 'Two\nlines \\x' }}", `(2:7) Invalid escape sequence '\x'`},
+		{`{{ "Où est" @ }}`, "(1:13) Illegal character '@'"},
+	}
+
+	for i, test := range tests {
+		l := New(test.input)
+
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		if len(l.Errors) != 1 {
+			t.Fatalf("(%d) Wrong number of errors. Expected 1 got %d", i, len(l.Errors))
+		}
+
+		if l.Errors[0].Error() != test.errorStr {
+			t.Errorf("(%d) Wrong error. Expected `%s` got `%s`", i, test.errorStr, l.Errors[0])
+		}
+	}
+}
+
 func testTemplateGeneratesTokens(t *testing.T, template string, expectedTokens []ExpectedToken) {
-	l := New(template)
+	testLexerGeneratesTokens(t, New(template), expectedTokens)
+}
+
+func testLexerGeneratesTokens(t *testing.T, l *Lexer, expectedTokens []ExpectedToken) {
 
 	for i, tt := range expectedTokens {
 		tok := l.NextToken()
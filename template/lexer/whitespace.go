@@ -0,0 +1,317 @@
+package lexer
+
+import (
+	"strings"
+
+	"github.com/jasonroelofs/late/template/token"
+)
+
+/**
+ * Late keeps tags from leaving their mark on the output. Before tokenizing,
+ * the lexer works out which whitespace in the template's text to leave out
+ * of the RAW tokens:
+ *
+ * Lines holding nothing but tags and comments are removed entirely,
+ * newline included, as long as the tags are ones that never output anything
+ * themselves. See SilentTags.
+ *
+ * Content indented under a tag on its own line is un-indented by however
+ * far it is indented past that tag, so that
+ *
+ *	<ul>
+ *	  {% for item in list %}
+ *	    <li>{{ item }}</li>
+ *	  {% end %}
+ *	</ul>
+ *
+ * outputs each <li> at the same indentation as the `for`.
+ *
+ * A `-` trim marker on an opening delimiter ({%-, {{-, {#-, {{{-) removes all
+ * whitespace before it, newlines included. On a closing delimiter
+ * (-%}, -}}, -#}, -}}}) it removes all whitespace after it.
+ */
+
+type segmentKind int
+
+const (
+	textSegment segmentKind = iota
+
+	// Tags and comments, which never output anything themselves
+	silentSegment
+
+	// Variables, verbatim blocks and tags that output something, like include
+	outputSegment
+)
+
+// A stretch of the template that is either text or a single piece of code
+type segment struct {
+	kind       segmentKind
+	start, end int
+
+	trimBefore  bool
+	trimAfter   bool
+	closesBlock bool
+}
+
+type line struct {
+	start  int
+	indent int
+
+	silent      bool
+	output      bool
+	text        bool
+	closesBlock bool
+
+	// The line's text, including its newline
+	textSpans [][2]int
+}
+
+// Each tag on its own line is a frame, under which content is un-indented
+type frame struct {
+	indent   int
+	extra    int
+	resolved bool
+}
+
+type dropper struct {
+	input   string
+	dropped []bool
+}
+
+func droppedWhitespace(source *Lexer) []bool {
+	d := &dropper{input: source.input}
+	segments := scanSegments(source)
+
+	d.applyTrimMarkers(segments)
+	d.trimLines(splitLines(source.input, segments))
+
+	return d.dropped
+}
+
+// Find where the template's code is, using a lexer that leaves whitespace alone
+// but otherwise starts out just like the source lexer.
+func scanSegments(source *Lexer) []*segment {
+	scanner := newLexer(source.input)
+	scanner.delimiters = source.delimiters
+	scanner.lookPosition = source.lookPosition
+	delimiters := source.delimiters
+
+	var segments []*segment
+	var code *segment
+	var tagName bool
+
+	for {
+		tok := scanner.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+
+		start, end := scanner.tokenStart, scanner.lookPosition
+
+		// Tags are only silent if we know they don't output anything
+		if tagName && source.silentTag != nil && !source.silentTag(tok.Literal) {
+			code.kind = outputSegment
+		}
+
+		tagName = tok.Type == token.OPEN_TAG
+
+		switch tok.Type {
+		case token.OPEN_TAG, token.OPEN_COMMENT, token.OPEN_VAR, token.OPEN_RAW:
+			kind := silentSegment
+			if tok.Type == token.OPEN_VAR || tok.Type == token.OPEN_RAW {
+				kind = outputSegment
+			}
+
+			code = &segment{kind: kind, start: start, trimBefore: strings.HasSuffix(tok.Literal, "-")}
+			segments = append(segments, code)
+		case token.END:
+			code = nil
+			segments = append(segments, &segment{
+				kind:        silentSegment,
+				start:       start,
+				end:         end,
+				trimBefore:  strings.HasPrefix(tok.Literal, delimiters.OpenTag+"-"),
+				trimAfter:   strings.HasSuffix(tok.Literal, "-"+delimiters.CloseTag),
+				closesBlock: true,
+			})
+		case token.CLOSE_TAG, token.CLOSE_COMMENT, token.CLOSE_VAR, token.CLOSE_RAW:
+			if code != nil {
+				code.end = end
+				code.trimAfter = strings.HasPrefix(tok.Literal, "-")
+				code = nil
+			}
+		case token.RAW:
+			if code == nil {
+				segments = append(segments, &segment{kind: textSegment, start: start, end: end})
+			}
+		}
+
+		// Unterminated code runs to the end of the template
+		if code != nil {
+			code.end = end
+		}
+	}
+
+	return segments
+}
+
+func (d *dropper) applyTrimMarkers(segments []*segment) {
+	for i, seg := range segments {
+		if seg.trimBefore && i > 0 && segments[i-1].kind == textSegment {
+			text := segments[i-1]
+			end := text.end
+
+			for end > text.start && isWhitespace(d.input[end-1]) {
+				end--
+			}
+
+			d.drop(end, text.end)
+		}
+
+		if seg.trimAfter && i+1 < len(segments) && segments[i+1].kind == textSegment {
+			text := segments[i+1]
+			start := text.start
+
+			for start < text.end && isWhitespace(d.input[start]) {
+				start++
+			}
+
+			d.drop(text.start, start)
+		}
+	}
+}
+
+// Break the template into lines. Newlines inside of code don't count.
+func splitLines(input string, segments []*segment) []*line {
+	current := &line{}
+	lines := []*line{current}
+
+	for _, seg := range segments {
+		switch seg.kind {
+		case silentSegment:
+			current.silent = true
+			current.closesBlock = seg.closesBlock
+		case outputSegment:
+			current.output = true
+			current.closesBlock = false
+		case textSegment:
+			start := seg.start
+
+			for i := seg.start; i < seg.end; i++ {
+				if input[i] == '\n' {
+					current.addText(input, start, i+1)
+					current = &line{start: i + 1}
+					lines = append(lines, current)
+					start = i + 1
+				}
+			}
+
+			current.addText(input, start, seg.end)
+		}
+	}
+
+	return lines
+}
+
+func (ln *line) addText(input string, start, end int) {
+	if start == end {
+		return
+	}
+
+	if start == ln.start {
+		for i := start; i < end && (input[i] == ' ' || input[i] == '\t'); i++ {
+			ln.indent++
+		}
+	}
+
+	if strings.TrimSpace(input[start:end]) != "" {
+		ln.text = true
+	}
+
+	ln.textSpans = append(ln.textSpans, [2]int{start, end})
+}
+
+func (ln *line) tagsOnly() bool {
+	return ln.silent && !ln.output && !ln.text
+}
+
+func (d *dropper) trimLines(lines []*line) {
+	var frames []*frame
+
+	for _, ln := range lines {
+		// Blank lines are left as they are
+		if !ln.silent && !ln.output && !ln.text {
+			continue
+		}
+
+		// Coming back out to a tag's indentation means we're done with its content
+		for len(frames) > 0 && frames[len(frames)-1].indent >= ln.indent {
+			frames = frames[:len(frames)-1]
+		}
+
+		// The first line indented past a tag decides how far to un-indent its content
+		if len(frames) > 0 && !frames[len(frames)-1].resolved {
+			top := frames[len(frames)-1]
+			top.extra = ln.indent - top.indent
+			top.resolved = true
+		}
+
+		if ln.tagsOnly() {
+			for _, span := range ln.textSpans {
+				d.drop(span[0], span[1])
+			}
+
+			if !ln.closesBlock {
+				frames = append(frames, &frame{indent: ln.indent})
+			}
+
+			continue
+		}
+
+		dedent := 0
+		for _, f := range frames {
+			dedent += f.extra
+		}
+
+		if dedent > ln.indent {
+			dedent = ln.indent
+		}
+
+		d.drop(ln.start, ln.start+dedent)
+	}
+}
+
+func (d *dropper) drop(start, end int) {
+	if start >= end {
+		return
+	}
+
+	if d.dropped == nil {
+		d.dropped = make([]bool, len(d.input))
+	}
+
+	for i := start; i < end; i++ {
+		d.dropped[i] = true
+	}
+}
+
+// The template's text from start to end, less any dropped whitespace
+func (l *Lexer) keptText(start, end int) string {
+	if l.dropped == nil {
+		return l.input[start:end]
+	}
+
+	var buffer []byte
+
+	for i := start; i < end; i++ {
+		if !l.dropped[i] {
+			buffer = append(buffer, l.input[i])
+		}
+	}
+
+	return string(buffer)
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
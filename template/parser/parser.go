@@ -1,11 +1,11 @@
 package parser
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/jasonroelofs/late"
+	"github.com/jasonroelofs/late/errors"
 	"github.com/jasonroelofs/late/tag"
 	"github.com/jasonroelofs/late/template/ast"
 	"github.com/jasonroelofs/late/template/lexer"
@@ -17,30 +17,38 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN  // =
+	LOGICAL // and, or, &&, ||
 	PIPE    // '|' (filter seperator)
-	EQUALS  // ==, !=
+	EQUALS  // ==, !=, contains
 	COMPARE // <, >, <=, >=
+	RANGE   // ..
 	SUM     // +, -
-	PRODUCT // *, /
+	PRODUCT // *, /, //, %
 	PREFIX  // -X
 	INDEX   // []
 )
 
 var precedences = map[token.TokenType]int{
-	token.ASSIGN:  ASSIGN,
-	token.PIPE:    PIPE,
-	token.EQ:      EQUALS,
-	token.NOT_EQ:  EQUALS,
-	token.LT:      COMPARE,
-	token.GT:      COMPARE,
-	token.LT_EQ:   COMPARE,
-	token.GT_EQ:   COMPARE,
-	token.PLUS:    SUM,
-	token.MINUS:   SUM,
-	token.SLASH:   PRODUCT,
-	token.TIMES:   PRODUCT,
-	token.LSQUARE: INDEX,
-	token.DOT:     INDEX,
+	token.ASSIGN:   ASSIGN,
+	token.AND:      LOGICAL,
+	token.OR:       LOGICAL,
+	token.PIPE:     PIPE,
+	token.EQ:       EQUALS,
+	token.NOT_EQ:   EQUALS,
+	token.CONTAINS: EQUALS,
+	token.LT:       COMPARE,
+	token.GT:       COMPARE,
+	token.LT_EQ:    COMPARE,
+	token.GT_EQ:    COMPARE,
+	token.RANGE:    RANGE,
+	token.PLUS:     SUM,
+	token.MINUS:    SUM,
+	token.SLASH:    PRODUCT,
+	token.TIMES:    PRODUCT,
+	token.INT_DIV:  PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.LSQUARE:  INDEX,
+	token.DOT:      INDEX,
 }
 
 type (
@@ -50,7 +58,11 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	Errors []string
+	engine *late.Engine
+	Errors []*errors.Error
+
+	// How many of the lexer's errors have been moved into Errors
+	lexerErrors int
 
 	currToken token.Token
 	peekToken token.Token
@@ -65,22 +77,26 @@ type Parser struct {
 	currentTagStack []*ast.TagStatement
 }
 
-func New(lexer *lexer.Lexer) *Parser {
-	p := &Parser{l: lexer}
+func New(lexer *lexer.Lexer, engine *late.Engine) *Parser {
+	p := &Parser{l: lexer, engine: engine}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.NUMBER, p.parseNumberLiteral)
 	p.registerPrefix(token.TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.FALSE, p.parseBooleanLiteral)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
 	p.registerPrefix(token.LSQUARE, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseHashLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.CONTAINS, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
@@ -89,6 +105,11 @@ func New(lexer *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.TIMES, p.parseInfixExpression)
+	p.registerInfix(token.INT_DIV, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.RANGE, p.parseRangeLiteral)
 	p.registerInfix(token.PIPE, p.parseFilterExpression)
 	p.registerInfix(token.LSQUARE, p.parseIndexExpression)
 	p.registerInfix(token.DOT, p.parseDotExpression)
@@ -112,10 +133,15 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 func (p *Parser) nextToken() {
 	p.currToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// Keep lexing errors in line with parser errors
+	for ; p.lexerErrors < len(p.l.Errors); p.lexerErrors++ {
+		p.Errors = append(p.Errors, p.l.Errors[p.lexerErrors])
+	}
 }
 
 func (p *Parser) Parse() *ast.Template {
-	template := &ast.Template{}
+	template := &ast.Template{Source: p.l.Input()}
 
 	for !p.currTokenIs(token.EOF) {
 		stmt := p.parseNext()
@@ -129,19 +155,28 @@ func (p *Parser) Parse() *ast.Template {
 	return template
 }
 
+// parseNext returns nil if the next statement could not be parsed
 func (p *Parser) parseNext() ast.Statement {
 	switch p.currToken.Type {
 	case token.OPEN_VAR:
-		return p.parseVariableStatement()
+		if stmt := p.parseVariableStatement(); stmt != nil {
+			return stmt
+		}
 	case token.OPEN_TAG:
 		return p.parseTagStatement()
 	case token.OPEN_COMMENT:
-		return p.parseCommentStatement()
+		if stmt := p.parseCommentStatement(); stmt != nil {
+			return stmt
+		}
 	case token.OPEN_RAW:
-		return p.parseVerbatimStatement()
+		if stmt := p.parseVerbatimStatement(); stmt != nil {
+			return stmt
+		}
 	default:
 		return p.parseRawStatement()
 	}
+
+	return nil
 }
 
 func (p *Parser) parseRawStatement() *ast.RawStatement {
@@ -185,9 +220,17 @@ func (p *Parser) parseVariableStatement() *ast.VariableStatement {
 	// and parse the content into an expression tree
 	p.nextToken()
 
+	errorsWas := len(p.Errors)
 	stmt.Expression = p.parseExpression(LOWEST)
 
+	// A broken expression has already been reported, so skip the rest of it
+	if len(p.Errors) > errorsWas {
+		p.skipTo(token.CLOSE_VAR)
+		return nil
+	}
+
 	if !p.expectPeek(token.CLOSE_VAR) {
+		p.skipTo(token.CLOSE_VAR)
 		return nil
 	}
 
@@ -206,6 +249,7 @@ func (p *Parser) parseTagStatement() ast.Statement {
 	var currentParseConfig *tag.ParseConfig
 	var inSubTag bool
 	currTagName := p.currToken.Literal
+	errorsWas := len(p.Errors)
 
 	stmt := p.currentTag()
 
@@ -221,11 +265,12 @@ func (p *Parser) parseTagStatement() ast.Statement {
 		stmt = &ast.TagStatement{
 			Token:   p.currToken,
 			TagName: tagName,
-			Tag:     late.FindTag(tagName),
+			Tag:     p.engine.FindTag(tagName),
 		}
 
 		if stmt.Tag == nil {
-			p.parserErrorf("Unknown tag '%s'", stmt.TagName)
+			p.parserErrorf(errors.UnknownTag, "Unknown tag '%s'", stmt.TagName)
+			p.skipTo(token.CLOSE_TAG)
 			return nil
 		}
 
@@ -235,6 +280,14 @@ func (p *Parser) parseTagStatement() ast.Statement {
 	} else if stmt.HasSubTag(currTagName) {
 		// We have a subtag!
 		inSubTag = true
+
+		for _, previous := range stmt.SubTags {
+			if stmt.SubTagConfig(previous.TagName).Last {
+				p.parserErrorf(errors.InvalidTag, "Error parsing tag '%s': '%s' can't come after '%s'", stmt.TagName, currTagName, previous.TagName)
+				break
+			}
+		}
+
 		subStmt := &ast.TagStatement{
 			Token:   p.currToken,
 			TagName: currTagName,
@@ -247,7 +300,7 @@ func (p *Parser) parseTagStatement() ast.Statement {
 		// From here on out, the subtag now behaves as a tag in its own right,
 		// but is not pushed onto the stack so further sub-tags can be applied.
 		stmt = subStmt
-	} else if nestedTag := late.FindTag(currTagName); nestedTag != nil {
+	} else if nestedTag := p.engine.FindTag(currTagName); nestedTag != nil {
 		// We actually are starting a new tag in the nested context of the current tag.
 		// Build our new tag statement and make it the current
 		stmt = &ast.TagStatement{
@@ -259,58 +312,47 @@ func (p *Parser) parseTagStatement() ast.Statement {
 		p.pushCurrentTag(stmt)
 		currentParseConfig = stmt.Tag.Parse()
 	} else {
-		p.parserErrorf("Unknown tag '%s'", stmt.TagName)
+		p.parserErrorf(errors.UnknownTag, "Unknown tag '%s'", currTagName)
+		p.skipTo(token.CLOSE_TAG)
 		return nil
 	}
 
 	if currentParseConfig.Interrupt {
 		p.popCurrentTag()
-		return &ast.InterruptStatement{Token: p.currToken, Name: stmt.TagName, Tag: stmt.Tag}
-	}
-
-	for _, parseRule := range currentParseConfig.Rules {
-		expectedTokenType := p.parseRuleToTokenType(parseRule)
+		interrupt := &ast.InterruptStatement{Token: p.currToken, Name: stmt.TagName, Tag: stmt.Tag}
 
-		if p.peekTokenIs(token.CLOSE_TAG) || p.peekTokenIs(token.EOF) {
-			p.parserErrorf("Error parsing tag '%s': expected %s", stmt.TagName, expectedTokenType)
-			break
+		if !p.expectPeek(token.CLOSE_TAG) {
+			p.skipTo(token.CLOSE_TAG)
+			return nil
 		}
 
 		p.nextToken()
+		return interrupt
+	}
 
-		if expectedTokenType != token.EXPRESSION && !p.currTokenIs(expectedTokenType) {
-			p.parserErrorf("Error parsing nodes for tag '%s': expected %s found %s", stmt.TagName, expectedTokenType, p.currToken.Type)
+	for _, parseRule := range currentParseConfig.Rules {
+		node, ok := p.parseTagRule(stmt, parseRule)
+		if !ok {
 			break
 		}
 
-		switch parseRule := parseRule.(type) {
-		case *tag.IdentifierRule:
-			stmt.Nodes = append(stmt.Nodes, &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal})
-		case *tag.LiteralRule:
-			if p.currToken.Literal != parseRule.Value {
-				p.parserErrorf("Error parsing nodes for tag '%s': expected literal `%s` found `%s`", stmt.TagName, parseRule.Value, p.currToken.Literal)
-				break
-			}
-
-			stmt.Nodes = append(stmt.Nodes, &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal})
-		case *tag.ExpressionRule:
-			stmt.Nodes = append(stmt.Nodes, p.parseExpression(LOWEST))
-		case *tag.TokenRule:
-			stmt.Nodes = append(stmt.Nodes, &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal})
-		default:
-			p.parserErrorf("Error parsing tag '%s': Don't know how to handle ParseRule of type %T", stmt.TagName, parseRule)
-			return nil
-		}
+		stmt.Nodes = append(stmt.Nodes, node)
 	}
 
-	if !p.expectPeek(token.CLOSE_TAG) {
-		return nil
-	}
+	// A tag we couldn't fully parse can't be evaluated, but we still need to
+	// work through its block (if it has one) so that parsing can pick back up
+	// after the tag, rendering everything around it.
+	invalid := len(p.Errors) > errorsWas
 
-	// Move to our %} token so we can continue
-	p.nextToken()
+	if !invalid && p.expectPeek(token.CLOSE_TAG) {
+		// Move to our %} token so we can continue
+		p.nextToken()
+	} else {
+		invalid = true
+		p.skipTo(token.CLOSE_TAG)
+	}
 
-	if currentParseConfig.Block {
+	if currentParseConfig.Block && !p.currTokenIs(token.EOF) {
 		stmt.BlockStatement = p.parseBlockStatement()
 	}
 
@@ -322,7 +364,7 @@ func (p *Parser) parseTagStatement() ast.Statement {
 
 		if currentParseConfig.Block {
 			if !p.peekTokenIs(token.END) {
-				p.parserErrorf("Error parsing tag '%s': expected %s found %s", stmt.TagName, token.END, p.peekToken.Type)
+				p.parserErrorf(errors.InvalidTag, "Error parsing tag '%s': expected %s found %s", stmt.TagName, token.END, p.peekToken.Type)
 				return nil
 			}
 
@@ -330,9 +372,37 @@ func (p *Parser) parseTagStatement() ast.Statement {
 		}
 	}
 
+	if invalid {
+		if inSubTag {
+			stmt.Owner.SubTags = removeSubTag(stmt.Owner.SubTags, stmt)
+		}
+
+		return nil
+	}
+
 	return stmt
 }
 
+// Later sub-tags are parsed as part of this one's block, so it isn't
+// necessarily the last one.
+func removeSubTag(subTags []*ast.TagStatement, subTag *ast.TagStatement) []*ast.TagStatement {
+	for i, found := range subTags {
+		if found == subTag {
+			return append(subTags[:i], subTags[i+1:]...)
+		}
+	}
+
+	return subTags
+}
+
+// When we find invalid code, skip ahead to the token that closes it off
+// so we can continue parsing the rest of the template.
+func (p *Parser) skipTo(tokenType token.TokenType) {
+	for !p.currTokenIs(tokenType) && !p.currTokenIs(token.EOF) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) pushCurrentTag(tagStmt *ast.TagStatement) {
 	p.currentTagStack = append(p.currentTagStack, tagStmt)
 }
@@ -352,20 +422,6 @@ func (p *Parser) popCurrentTag() *ast.TagStatement {
 	return top
 }
 
-func (p *Parser) parseRuleToTokenType(parseRule tag.ParseRule) token.TokenType {
-	switch parseRule := parseRule.(type) {
-	case *tag.IdentifierRule, *tag.LiteralRule:
-		return token.IDENT
-	case *tag.TokenRule:
-		return parseRule.Type
-	case *tag.ExpressionRule:
-		return token.EXPRESSION
-	default:
-		p.parserErrorf("Don't know how to convert parseRule type %T to a token.TokenType", parseRule)
-		return token.ILLEGAL
-	}
-}
-
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{}
 	currTag := p.currentTag()
@@ -380,6 +436,10 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		// any tag statements generated here and if the tag is actually a sub-tag
 		// then we need to not include that tag in the block statements list of
 		// the parent block tag.
+		if nextStmt == nil {
+			continue
+		}
+
 		asTag, ok := nextStmt.(*ast.TagStatement)
 
 		if !ok || asTag.Owner != currTag {
@@ -393,6 +453,14 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.currToken.Type]
 	if prefix == nil {
+		// Running out of template is reported by whatever expected more of it
+		if !p.currTokenIs(token.EOF) {
+			p.Errors = append(p.Errors, errors.New(
+				errors.UnexpectedToken, p.missingExpressionToken(),
+				"Expected an expression, found '%s'", p.currToken.Literal,
+			))
+		}
+
 		return nil
 	}
 
@@ -420,7 +488,13 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 
 	p.nextToken()
 
-	expression.Right = p.parseExpression(PREFIX)
+	// The word `not` reads like English, applying to the whole comparison
+	// after it: `not a > b` is `not (a > b)`, while `!a > b` is `(!a) > b`.
+	if expression.Operator == "not" {
+		expression.Right = p.parseExpression(LOGICAL)
+	} else {
+		expression.Right = p.parseExpression(PREFIX)
+	}
 
 	return expression
 }
@@ -503,7 +577,7 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	p.nextToken()
 	expr.Index = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RSQUARE) {
+	if expr.Index == nil || !p.expectPeek(token.RSQUARE) {
 		return nil
 	}
 
@@ -529,12 +603,24 @@ func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
 	return expr
 }
 
+func (p *Parser) parseRangeLiteral(start ast.Expression) ast.Expression {
+	expr := &ast.RangeLiteral{
+		Token: p.currToken,
+		Start: start,
+	}
+
+	p.nextToken()
+	expr.End = p.parseExpression(RANGE)
+
+	return expr
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
+	if exp == nil || !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
@@ -568,7 +654,7 @@ func (p *Parser) parseNumberLiteral() ast.Expression {
 
 	number, err := strconv.ParseFloat(p.currToken.Literal, 64)
 	if err != nil {
-		p.parserErrorf("could not parse %q as a number", p.currToken.Literal)
+		p.parserErrorf(errors.InvalidNumber, "could not parse %q as a number", p.currToken.Literal)
 		return nil
 	}
 
@@ -580,6 +666,10 @@ func (p *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.currToken, Value: p.currToken.Type == token.TRUE}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.currToken}
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 }
@@ -595,13 +685,24 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 	// First element
 	p.nextToken()
-	array.Expressions = append(array.Expressions, p.parseExpression(LOWEST))
+	element := p.parseExpression(LOWEST)
+	if element == nil {
+		return nil
+	}
+
+	array.Expressions = append(array.Expressions, element)
 
 	// Rest of the elements
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		array.Expressions = append(array.Expressions, p.parseExpression(LOWEST))
+
+		element = p.parseExpression(LOWEST)
+		if element == nil {
+			return nil
+		}
+
+		array.Expressions = append(array.Expressions, element)
 	}
 
 	if !p.expectPeek(token.RSQUARE) {
@@ -613,6 +714,49 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	return array
 }
 
+// Hash keys can be bare words or strings: { key: value, "quoted key": value }
+// A trailing comma is allowed.
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.currToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		if !p.expectPeek(token.IDENT, token.STRING) {
+			return nil
+		}
+
+		p.nextToken()
+		key := &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		p.nextToken()
+
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if p.peekTokenIs(token.RBRACKET) {
+			break
+		}
+
+		if !p.expectPeek(token.COMMA) {
+			return nil
+		}
+
+		p.nextToken()
+	}
+
+	p.nextToken()
+
+	return hash
+}
+
 func (p *Parser) expectPeek(allowed ...token.TokenType) bool {
 	matched := false
 	currPeek := p.peekToken.Type
@@ -652,15 +796,32 @@ func (p *Parser) expectedTokenError(got token.TokenType, expected ...token.Token
 		tokenNames = append(tokenNames, string(t))
 	}
 
-	msg := fmt.Sprintf(tokenLoc(p.peekToken)+"Expected %s, found %s", strings.Join(tokenNames, " or "), got)
-	p.Errors = append(p.Errors, msg)
+	p.Errors = append(p.Errors, errors.New(
+		errors.UnexpectedToken, p.peekToken,
+		"Expected %s, found %s", strings.Join(tokenNames, " or "), got,
+	))
 }
 
-func (p *Parser) parserErrorf(message string, args ...interface{}) {
-	msg := fmt.Sprintf(tokenLoc(p.currToken)+message, args...)
-	p.Errors = append(p.Errors, msg)
+// An expression cut short by the closing delimiter is reported where the
+// expression should have started, right after whatever came before it.
+func (p *Parser) missingExpressionToken() token.Token {
+	tok := p.currToken
+	if !p.currTokenIs(token.CLOSE_VAR) && !p.currTokenIs(token.CLOSE_TAG) {
+		return tok
+	}
+
+	code := strings.TrimLeft(tok.Raw, " \t\r\n")
+	space := tok.Raw[:len(tok.Raw)-len(code)]
+	if space == "" || strings.ContainsAny(space, "\r\n") {
+		return tok
+	}
+
+	tok.Char -= len(space)
+	tok.Offset -= len(space)
+	tok.Raw = space
+	return tok
 }
 
-func tokenLoc(t token.Token) string {
-	return fmt.Sprintf("(%d:%d) ", t.Line, t.Char)
+func (p *Parser) parserErrorf(code errors.Code, message string, args ...interface{}) {
+	p.Errors = append(p.Errors, errors.New(code, p.currToken, message, args...))
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"testing"
 
+	"github.com/jasonroelofs/late"
 	"github.com/jasonroelofs/late/template/ast"
 	"github.com/jasonroelofs/late/template/lexer"
 )
@@ -27,19 +28,23 @@ func TestParserErrors(t *testing.T) {
 	}{
 		{"{{", "(1:1) Expected CLOSE_VAR, found EOF"},
 		{"{{ foobar ", "(1:10) Expected CLOSE_VAR, found EOF"},
-		{"{{ %}", "(1:3) Expected CLOSE_VAR, found EOF"},
+		{"{{ %}", "(1:3) Expected an expression, found '%}'"},
+		{"{{ ) }}", "(1:4) Expected an expression, found ')'"},
+		{"{{ 1 + }}", "(1:7) Expected an expression, found '}}'"},
+		{"{{ [1, ] }}", "(1:8) Expected an expression, found ']'"},
+		{"{{ a[) }}", "(1:6) Expected an expression, found ')'"},
 	}
 
 	for i, test := range tests {
 		l := lexer.New(test.input)
-		p := New(l)
+		p := New(l, late.DefaultEngine())
 		p.Parse()
 
 		if len(p.Errors) != 1 {
 			t.Fatalf("(%d) Parser didn't find right # of errors. Found %d\n%#v\n", i, len(p.Errors), p.Errors)
 		}
 
-		if p.Errors[0] != test.errorStr {
+		if p.Errors[0].Error() != test.errorStr {
 			t.Fatalf("(%d) Wrong error. Wanted: \"%s\" Got: \"%s\"", i, test.errorStr, p.Errors[0])
 		}
 	}
@@ -89,6 +94,20 @@ func TestBooleanLiteral(t *testing.T) {
 	}
 }
 
+func TestNullLiteral(t *testing.T) {
+	tests := []string{"{{ null }}", "{{ nil }}"}
+
+	for i, input := range tests {
+		template := parseTest(t, input)
+		checkStatementCount(t, template, 1)
+
+		stmt := getVariableStatement(t, template, 0)
+		if _, ok := stmt.Expression.(*ast.NullLiteral); !ok {
+			t.Errorf("(%d) Expression not a NullLiteral, got %T", i, stmt.Expression)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -97,7 +116,7 @@ func TestStringLiteral(t *testing.T) {
 		{`{{ "A string" }}`, "A string"},
 		{`{{ 'Single Quotes' }}`, "Single Quotes"},
 		{`{{ "Mixe'd Quotes" }}`, "Mixe'd Quotes"},
-		{`{{ 'Escape\'d Quotes' }}`, "Escape\\'d Quotes"},
+		{`{{ 'Escape\'d Quotes' }}`, "Escape'd Quotes"},
 	}
 
 	for _, test := range tests {
@@ -151,6 +170,8 @@ func TestNumberInfixExpressions(t *testing.T) {
 		{"{{ 2 - 2 }}", 2, "-", 2},
 		{"{{ 3 * 3 }}", 3, "*", 3},
 		{"{{ 4 / 4 }}", 4, "/", 4},
+		{"{{ 4 // 4 }}", 4, "//", 4},
+		{"{{ 4 % 4 }}", 4, "%", 4},
 		{"{{ 5 < 5 }}", 5, "<", 5},
 		{"{{ 5 <= 5 }}", 5, "<=", 5},
 		{"{{ 6 > 6 }}", 6, ">", 6},
@@ -214,6 +235,92 @@ func TestNumberInfixExpressions(t *testing.T) {
 //	}
 //}
 
+func TestLogicalPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{{ a and b }}", "(a and b)"},
+		{"{{ a || b }}", "(a || b)"},
+		{"{{ not a }}", "(not a)"},
+		{"{{ !a }}", "(!a)"},
+
+		// and & or are evaluated left to right, use parens to group
+		{"{{ a and b or c }}", "((a and b) or c)"},
+		{"{{ a or b and c }}", "((a or b) and c)"},
+		{"{{ a or (b and c) }}", "(a or (b and c))"},
+
+		// Everything else binds tighter
+		{"{{ a < 1 and b == c }}", "((a < 1) and (b == c))"},
+		{"{{ !a == b }}", "((!a) == b)"},
+		{"{{ not a and b }}", "((not a) and b)"},
+		{"{{ not a > b }}", "(not (a > b))"},
+		{"{{ not a contains 5 }}", "(not (a contains 5))"},
+		{"{{ not a | size == 0 or b }}", "((not ((a | size) == 0)) or b)"},
+		{"{{ !a > b }}", "((!a) > b)"},
+		{`{{ a contains "x" or b contains c + 1 }}`, `((a contains "x") or (b contains (c + 1)))`},
+		{`{{ a | contains: "x" or b }}`, "((a | contains) or b)"},
+	}
+
+	for i, test := range tests {
+		template := parseTest(t, test.input)
+		checkStatementCount(t, template, 1)
+
+		stmt := getVariableStatement(t, template, 0)
+
+		if grouped := groupExpression(stmt.Expression); grouped != test.expected {
+			t.Errorf("(%d) Wrong precedence. Expected %s got %s", i, test.expected, grouped)
+		}
+	}
+}
+
+// Render an expression with explicit grouping so precedence can be checked
+func groupExpression(exp ast.Expression) string {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return "(" + groupExpression(exp.Left) + " " + exp.Operator + " " + groupExpression(exp.Right) + ")"
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			return "(!" + groupExpression(exp.Right) + ")"
+		}
+
+		return "(" + exp.Operator + " " + groupExpression(exp.Right) + ")"
+	case *ast.FilterExpression:
+		return "(" + groupExpression(exp.Input) + " | " + exp.Filter.(*ast.FilterLiteral).Name + ")"
+	case *ast.RangeLiteral:
+		return "(" + groupExpression(exp.Start) + ".." + groupExpression(exp.End) + ")"
+	default:
+		return exp.String()
+	}
+}
+
+func TestRangeParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{{ (1..5) }}", "(1..5)"},
+		{"{{ 1..5 }}", "(1..5)"},
+		{"{{ (a..b.c) }}", "(a..b[\"c\"])"},
+		{"{{ (1..n + 1) }}", "(1..(n + 1))"},
+		{"{{ (1..n) | size }}", "((1..n) | size)"},
+		{"{{ list[1..3] }}", "list[(1..3)]"},
+		{"{{ 1.5..2.5 }}", "(1.5..2.5)"},
+		{"{{ x < 1..3 }}", "(x < (1..3))"},
+	}
+
+	for i, test := range tests {
+		template := parseTest(t, test.input)
+		checkStatementCount(t, template, 1)
+
+		stmt := getVariableStatement(t, template, 0)
+
+		if grouped := groupExpression(stmt.Expression); grouped != test.expected {
+			t.Errorf("(%d) Wrong range. Expected %s got %s", i, test.expected, grouped)
+		}
+	}
+}
+
 func TestArrayParsing(t *testing.T) {
 	template := parseTest(t, `{{ [1, "two", three] }}`)
 	checkStatementCount(t, template, 1)
@@ -233,6 +340,73 @@ func TestArrayParsing(t *testing.T) {
 	checkIdentifierExpression(t, exp.Expressions[2], "three")
 }
 
+func TestHashParsing(t *testing.T) {
+	template := parseTest(t, `{{ { one: 1, "two words": "two", three: { four: four }, } }}`)
+	checkStatementCount(t, template, 1)
+
+	stmt := getVariableStatement(t, template, 0)
+	exp, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("stmt is not a HashLiteral, got %T", stmt.Expression)
+	}
+
+	if len(exp.Pairs) != 3 {
+		t.Fatalf("Wrong number of pairs: got %d", len(exp.Pairs))
+	}
+
+	expectedKeys := []string{"one", "two words", "three"}
+	for i, key := range expectedKeys {
+		if exp.Pairs[i].Key.Value != key {
+			t.Errorf("(%d) Wrong key. Expected %s got %s", i, key, exp.Pairs[i].Key.Value)
+		}
+	}
+
+	checkNumberLiteral(t, exp.Pairs[0].Value, float64(1))
+	checkStringLiteral(t, exp.Pairs[1].Value, "two")
+
+	nested, ok := exp.Pairs[2].Value.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("Nested value is not a HashLiteral, got %T", exp.Pairs[2].Value)
+	}
+
+	if len(nested.Pairs) != 1 || nested.Pairs[0].Key.Value != "four" {
+		t.Fatalf("Wrong nested hash, got %s", nested)
+	}
+
+	checkIdentifierExpression(t, nested.Pairs[0].Value, "four")
+
+	template = parseTest(t, `{{ {} }}`)
+	stmt = getVariableStatement(t, template, 0)
+
+	if exp, ok := stmt.Expression.(*ast.HashLiteral); !ok || len(exp.Pairs) != 0 {
+		t.Fatalf("Expected an empty HashLiteral, got %s", stmt.Expression)
+	}
+}
+
+func TestHashParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		errorStr string
+	}{
+		{"{{ {1: 2} }}", "(1:5) Expected IDENT or STRING, found NUMBER"},
+		{"{{ {a 2} }}", "(1:7) Expected COLON, found NUMBER"},
+		{"{{ {a: 1 b: 2} }}", "(1:10) Expected COMMA, found IDENT"},
+	}
+
+	for i, test := range tests {
+		p := New(lexer.New(test.input), late.DefaultEngine())
+		p.Parse()
+
+		if len(p.Errors) == 0 {
+			t.Fatalf("(%d) Expected an error, found none", i)
+		}
+
+		if p.Errors[0].Error() != test.errorStr {
+			t.Errorf("(%d) Wrong error. Wanted: \"%s\" Got: \"%s\"", i, test.errorStr, p.Errors[0])
+		}
+	}
+}
+
 func TestArrayPrecedence(t *testing.T) {
 	template := parseTest(t, `{{ [1 + 1, ("two" | size), 3 < 4] }}`)
 	checkStatementCount(t, template, 1)
@@ -396,6 +570,9 @@ func TestTags(t *testing.T) {
 		{`{% assign this = "that" %}`, "assign", 3},
 		{`{% capture variable %}{% end %}`, "capture", 1},
 		{`{% if true %}this{% end %}`, "if", 1},
+		{`{% for x in list %}{% end %}`, "for", 4},
+		{`{% for key, value in hash %}{% end %}`, "for", 4},
+		{`{% for x in list limit: 1 reversed %}{% end %}`, "for", 4},
 	}
 
 	for _, test := range tests {
@@ -439,6 +616,36 @@ func TestBasicIfTag(t *testing.T) {
 	}
 }
 
+func TestTagNamedNodes(t *testing.T) {
+	tests := []struct {
+		input string
+		named []string
+	}{
+		{`{% for x in list %}{% end %}`, []string{`variables: "x"`, "collection: list"}},
+		{`{% for k, v in hash %}{% end %}`, []string{`variables: "k", "v"`, "collection: hash"}},
+		{`{% for x in list reversed %}{% end %}`, []string{`variables: "x"`, "collection: list", "reversed: reversed"}},
+		{`{% for x in list limit: 2, offset: n + 1 %}{% end %}`, []string{`variables: "x"`, "collection: list", "limit: 2", "offset: n + 1"}},
+		{`{% for x in list | sort reversed limit: 1 %}{% end %}`, []string{`variables: "x"`, "collection: list | sort", "reversed: reversed", "limit: 1"}},
+	}
+
+	for i, test := range tests {
+		template := parseTest(t, test.input)
+		stmt := getTagStatement(t, template, 0)
+
+		if len(stmt.Named) != len(test.named) {
+			t.Fatalf("(%d) Wrong number of named nodes. Expected %d got %d", i, len(test.named), len(stmt.Named))
+		}
+
+		for j, named := range stmt.Named {
+			got := named.Name + ": " + named.Value.String()
+
+			if got != test.named[j] {
+				t.Errorf("(%d) Wrong named node. Expected `%s` got `%s`", i, test.named[j], got)
+			}
+		}
+	}
+}
+
 func TestIfElseSubTags(t *testing.T) {
 	input := "{% if true %}True{% else %}False{% end %}"
 
@@ -576,11 +783,29 @@ func TestTagErrors(t *testing.T) {
 		{`{% capture %}`, "(1:4) Error parsing tag 'capture': expected IDENT"},
 		{`{% capture %}{% end %}`, "(1:4) Error parsing tag 'capture': expected IDENT"},
 		{`{% capture var %}`, "(1:16) Error parsing tag 'capture': expected END found EOF"},
+
+		{`{% for key, in hash %}{% end %}`, "(1:16) Error parsing nodes for tag 'for': expected literal `in` found `hash`"},
+		{`{% for key, 1 in hash %}{% end %}`, "(1:13) Error parsing nodes for tag 'for': expected IDENT found NUMBER"},
+		{`{% for x in list limit %}{% end %}`, "(1:24) Expected COLON, found CLOSE_TAG"},
+		{`{% for x in list limit: %}{% end %}`, "(1:23) Error parsing tag 'for': expected EXPRESSION"},
+		{`{% for x in list sorted %}{% end %}`, "(1:18) Expected CLOSE_TAG, found IDENT"},
+		{`{% for x in list reversed reversed %}{% end %}`, "(1:27) Error parsing tag 'for': `reversed` given more than once"},
+		{`{% for x in list limit: 1, reversed %}{% end %}`, "(1:28) Error parsing nodes for tag 'for': expected `limit:` or `offset:` found IDENT"},
+		{`{% if x reversed %}{% end %}`, "(1:9) Expected CLOSE_TAG, found IDENT"},
+
+		{`{% when 1 %}`, "(1:4) Unknown tag 'when'"},
+		{`{% case %}{% end %}`, "(1:4) Error parsing tag 'case': expected EXPRESSION"},
+		{`{% case 1 %}{% when %}{% end %}`, "(1:16) Error parsing tag 'when': expected EXPRESSION"},
+		{`{% case 1 %}{% when 1, %}{% end %}`, "(1:22) Error parsing tag 'when': expected EXPRESSION"},
+		{`{% case 1 %}{% else %}{% when 1 %}{% end %}`, "(1:26) Error parsing tag 'case': 'when' can't come after 'else'"},
+		{`{% case 1 %}{% when 1 %}{% else %}{% else %}{% end %}`, "(1:38) Error parsing tag 'case': 'else' can't come after 'else'"},
+		{`{% if x %}{% else %}{% elsif y %}{% end %}`, "(1:24) Error parsing tag 'if': 'elsif' can't come after 'else'"},
+		{`{% for x in y %}{% else %}{% else %}{% end %}`, "(1:30) Error parsing tag 'for': 'else' can't come after 'else'"},
 	}
 
 	for _, test := range tests {
 		l := lexer.New(test.input)
-		p := New(l)
+		p := New(l, late.DefaultEngine())
 		p.Parse()
 
 		errors := p.Errors
@@ -589,19 +814,54 @@ func TestTagErrors(t *testing.T) {
 		}
 
 		err := errors[0]
-		if err != test.errorStr {
+		if err.Error() != test.errorStr {
 			t.Errorf("Wrong error string for '%s'. Expected `%s` got `%s`", test.input, test.errorStr, err)
 		}
 	}
 }
 
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input      string
+		numErrors  int
+		statements []string
+	}{
+		{`{{ 1 2 }} After`, 1, []string{" After"}},
+		{`{% explode now %} After`, 1, []string{" After"}},
+		{`{% assign = 1 %}{{ 2 }}`, 1, []string{"{{ 2 }}"}},
+		{`{% if %}Gone{% end %} After`, 1, []string{" After"}},
+		{`{% break now %}After`, 1, []string{"After"}},
+		{`{{ 1 }}{% if true %}{% explode %}{% end %}`, 1, []string{"{{ 1 }}", "{% iftrue %}{% end %}"}},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l, late.DefaultEngine())
+		template := p.Parse()
+
+		if len(p.Errors) != test.numErrors {
+			t.Fatalf("(%d) Wrong number of errors. Expected %d got %d: %v", i, test.numErrors, len(p.Errors), p.Errors)
+		}
+
+		if len(template.Statements) != len(test.statements) {
+			t.Fatalf("(%d) Wrong number of statements. Expected %d got %d", i, len(test.statements), len(template.Statements))
+		}
+
+		for j, stmt := range template.Statements {
+			if stmt.String() != test.statements[j] {
+				t.Errorf("(%d) Wrong statement. Expected `%s` got `%s`", i, test.statements[j], stmt.String())
+			}
+		}
+	}
+}
+
 /**
  * Helper methods
  */
 
 func parseTest(t *testing.T, input string) *ast.Template {
 	l := lexer.New(input)
-	p := New(l)
+	p := New(l, late.DefaultEngine())
 
 	template := p.Parse()
 	checkParserErrors(t, p)
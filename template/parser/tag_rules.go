@@ -0,0 +1,300 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/tag"
+	"github.com/jasonroelofs/late/template/ast"
+	"github.com/jasonroelofs/late/template/token"
+)
+
+/**
+ * Parse the part of a tag described by one of its tag.ParseRules.
+ * Every rule results in a single node, which combinators build up out of
+ * the nodes of their own rules.
+ * Returns false if the tag could not be parsed.
+ */
+func (p *Parser) parseTagRule(stmt *ast.TagStatement, parseRule tag.ParseRule) (ast.Expression, bool) {
+	switch parseRule := parseRule.(type) {
+	case *tag.OptionalRule:
+		if !p.ruleStartsHere(parseRule.Rules[0]) {
+			return &ast.NullLiteral{Token: token.Token{Type: token.NULL}}, true
+		}
+
+		if len(parseRule.Rules) == 1 {
+			return p.parseTagRule(stmt, parseRule.Rules[0])
+		}
+
+		values := &ast.TagValues{Token: p.peekToken}
+
+		for _, rule := range parseRule.Rules {
+			node, ok := p.parseTagRule(stmt, rule)
+			if !ok {
+				return nil, false
+			}
+
+			values.Values = append(values.Values, node)
+		}
+
+		return values, true
+
+	case *tag.OneOfRule:
+		for _, rule := range parseRule.Rules {
+			if p.ruleStartsHere(rule) {
+				return p.parseTagRule(stmt, rule)
+			}
+		}
+
+		p.expectedRuleError(stmt, parseRule)
+		return nil, false
+
+	case *tag.RepeatRule:
+		values := &ast.TagValues{Token: p.peekToken}
+
+		for p.ruleStartsHere(parseRule.Rule) {
+			node, ok := p.parseTagRule(stmt, parseRule.Rule)
+			if !ok {
+				return nil, false
+			}
+
+			values.Values = append(values.Values, node)
+		}
+
+		return values, true
+
+	case *tag.CommaListRule:
+		values := &ast.TagValues{Token: p.peekToken}
+
+		for {
+			node, ok := p.parseTagRule(stmt, parseRule.Rule)
+			if !ok {
+				return nil, false
+			}
+
+			values.Values = append(values.Values, node)
+
+			if !p.peekTokenIs(token.COMMA) {
+				return values, true
+			}
+
+			p.nextToken()
+		}
+
+	case *tag.KeywordArgsRule:
+		args := &ast.TagValues{Token: p.peekToken, Keys: []string{}}
+
+		for p.ruleStartsHere(parseRule) {
+			p.nextToken()
+			nameToken := p.currToken
+
+			if !p.expectPeek(token.COLON) {
+				return nil, false
+			}
+
+			p.nextToken()
+
+			value, ok := p.parseTagRule(stmt, tag.Expression())
+			if !ok || !p.addNamedNode(stmt, nameToken, nameToken.Literal, value) {
+				return nil, false
+			}
+
+			args.Keys = append(args.Keys, nameToken.Literal)
+			args.Values = append(args.Values, value)
+
+			if p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+
+				if !p.ruleStartsHere(parseRule) {
+					p.expectedRuleError(stmt, parseRule)
+					return nil, false
+				}
+			}
+		}
+
+		return args, true
+
+	case *tag.NamedRule:
+		nameToken := p.peekToken
+
+		node, ok := p.parseTagRule(stmt, parseRule.Rule)
+		if !ok || !p.addNamedNode(stmt, nameToken, parseRule.Name, node) {
+			return nil, false
+		}
+
+		return node, true
+
+	case *tag.FlagRule:
+		if !p.ruleStartsHere(parseRule) {
+			p.expectedRuleError(stmt, parseRule)
+			return nil, false
+		}
+
+		p.nextToken()
+		node := &ast.BooleanLiteral{Token: p.currToken, Value: true}
+
+		return node, p.addNamedNode(stmt, p.currToken, parseRule.Name, node)
+
+	case *tag.LazyExpressionRule:
+		startToken := p.peekToken
+
+		node, ok := p.parseTagRule(stmt, tag.Expression())
+		if !ok {
+			return nil, false
+		}
+
+		return &ast.LazyExpression{Token: startToken, Expression: node}, true
+
+	case *tag.IdentifierRule, *tag.LiteralRule, *tag.TokenRule, *tag.ExpressionRule:
+		if p.peekTokenIs(token.CLOSE_TAG, token.EOF) {
+			p.parserErrorf(errors.InvalidTag, "Error parsing tag '%s': expected %s", stmt.TagName, describeRule(parseRule))
+			return nil, false
+		}
+
+		p.nextToken()
+
+		if literal, ok := parseRule.(*tag.LiteralRule); ok && p.currToken.Literal != literal.Value {
+			p.parserErrorf(errors.InvalidTag, "Error parsing nodes for tag '%s': expected literal `%s` found `%s`", stmt.TagName, literal.Value, p.currToken.Literal)
+			return nil, false
+		}
+
+		if _, ok := parseRule.(*tag.ExpressionRule); ok {
+			if p.prefixParseFns[p.currToken.Type] == nil {
+				p.parserErrorf(errors.InvalidTag, "Error parsing nodes for tag '%s': expected %s found %s", stmt.TagName, token.EXPRESSION, p.currToken.Type)
+				return nil, false
+			}
+
+			expression := p.parseExpression(LOWEST)
+			return expression, expression != nil
+		}
+
+		if expected := ruleTokenType(parseRule); expected != "" && !p.currTokenIs(expected) {
+			p.parserErrorf(errors.InvalidTag, "Error parsing nodes for tag '%s': expected %s found %s", stmt.TagName, expected, p.currToken.Type)
+			return nil, false
+		}
+
+		return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}, true
+
+	default:
+		p.parserErrorf(errors.InvalidTag, "Error parsing tag '%s': Don't know how to handle ParseRule of type %T", stmt.TagName, parseRule)
+		return nil, false
+	}
+}
+
+// Whether the next token is the start of what the rule matches
+func (p *Parser) ruleStartsHere(parseRule tag.ParseRule) bool {
+	switch parseRule := parseRule.(type) {
+	case *tag.OptionalRule:
+		return p.ruleStartsHere(parseRule.Rules[0])
+	case *tag.OneOfRule:
+		for _, rule := range parseRule.Rules {
+			if p.ruleStartsHere(rule) {
+				return true
+			}
+		}
+
+		return false
+	case *tag.RepeatRule:
+		return p.ruleStartsHere(parseRule.Rule)
+	case *tag.CommaListRule:
+		return p.ruleStartsHere(parseRule.Rule)
+	case *tag.NamedRule:
+		return p.ruleStartsHere(parseRule.Rule)
+	case *tag.KeywordArgsRule:
+		if !p.peekTokenIs(token.IDENT) {
+			return false
+		}
+
+		for _, name := range parseRule.Names {
+			if name == p.peekToken.Literal {
+				return true
+			}
+		}
+
+		return len(parseRule.Names) == 0
+	case *tag.FlagRule:
+		return p.peekTokenIs(token.IDENT) && p.peekToken.Literal == parseRule.Name
+	case *tag.LiteralRule:
+		return p.peekToken.Literal == parseRule.Value
+	case *tag.ExpressionRule, *tag.LazyExpressionRule:
+		return p.prefixParseFns[p.peekToken.Type] != nil
+	default:
+		expected := ruleTokenType(parseRule)
+		return expected != "" && p.peekTokenIs(expected)
+	}
+}
+
+func (p *Parser) addNamedNode(stmt *ast.TagStatement, nameToken token.Token, name string, node ast.Expression) bool {
+	for _, named := range stmt.Named {
+		if named.Name == name {
+			p.Errors = append(p.Errors, errors.New(errors.InvalidTag, nameToken,
+				"Error parsing tag '%s': `%s` given more than once", stmt.TagName, name))
+			return false
+		}
+	}
+
+	stmt.Named = append(stmt.Named, &ast.NamedNode{Token: nameToken, Name: name, Value: node})
+	return true
+}
+
+func (p *Parser) expectedRuleError(stmt *ast.TagStatement, parseRule tag.ParseRule) {
+	if p.peekTokenIs(token.CLOSE_TAG, token.EOF) {
+		p.parserErrorf(errors.InvalidTag, "Error parsing tag '%s': expected %s", stmt.TagName, describeRule(parseRule))
+		return
+	}
+
+	p.nextToken()
+	p.parserErrorf(errors.InvalidTag, "Error parsing nodes for tag '%s': expected %s found %s", stmt.TagName, describeRule(parseRule), p.currToken.Type)
+}
+
+func ruleTokenType(parseRule tag.ParseRule) token.TokenType {
+	switch parseRule := parseRule.(type) {
+	case *tag.IdentifierRule:
+		return token.IDENT
+	case *tag.TokenRule:
+		return parseRule.Type
+	default:
+		return ""
+	}
+}
+
+// How to refer to a rule in error messages
+func describeRule(parseRule tag.ParseRule) string {
+	switch parseRule := parseRule.(type) {
+	case *tag.LiteralRule:
+		return fmt.Sprintf("`%s`", parseRule.Value)
+	case *tag.FlagRule:
+		return fmt.Sprintf("`%s`", parseRule.Name)
+	case *tag.ExpressionRule, *tag.LazyExpressionRule:
+		return token.EXPRESSION
+	case *tag.KeywordArgsRule:
+		var names []string
+		for _, name := range parseRule.Names {
+			names = append(names, fmt.Sprintf("`%s:`", name))
+		}
+
+		if len(names) == 0 {
+			return "a keyword argument"
+		}
+
+		return strings.Join(names, " or ")
+	case *tag.OptionalRule:
+		return describeRule(parseRule.Rules[0])
+	case *tag.OneOfRule:
+		var rules []string
+		for _, rule := range parseRule.Rules {
+			rules = append(rules, describeRule(rule))
+		}
+
+		return strings.Join(rules, " or ")
+	case *tag.RepeatRule:
+		return describeRule(parseRule.Rule)
+	case *tag.CommaListRule:
+		return describeRule(parseRule.Rule)
+	case *tag.NamedRule:
+		return describeRule(parseRule.Rule)
+	default:
+		return string(ruleTokenType(parseRule))
+	}
+}
@@ -0,0 +1,69 @@
+package template
+
+import (
+	"github.com/jasonroelofs/late"
+	"github.com/jasonroelofs/late/context"
+)
+
+// Find the compiled partial for name, from the engine's partial cache if it's
+// still current, compiling and caching it if not.
+func loadPartial(engine *late.Engine, name string, ctx *context.Context) (*Template, error) {
+	info, err := ctx.StatFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var key, body string
+	var isFresh func(*late.CachedPartial) bool
+
+	if info != nil {
+		// We know where the file is and when it changed, so there's no need
+		// to read it at all unless it has changed.
+		key = info.Path
+		isFresh = func(cached *late.CachedPartial) bool {
+			return cached.ModTime.Equal(info.ModTime) && cached.Size == info.Size
+		}
+	} else {
+		if body, err = ctx.ReadFile(name); err != nil {
+			return nil, err
+		}
+
+		// Different readers can have different files under the same name.
+		// The body is compared as well, as a new reader may reuse the ID of
+		// one that's gone.
+		key = ctx.ReaderID() + ":" + name
+		isFresh = func(cached *late.CachedPartial) bool {
+			return cached.Body == body
+		}
+	}
+
+	cached, err := engine.Partials().Fetch(key, isFresh, func() (*late.CachedPartial, error) {
+		if info != nil {
+			if body, err = ctx.ReadFile(name); err != nil {
+				return nil, err
+			}
+		}
+
+		tpl := New(body, Name(name), Engine(engine))
+		partial := &late.CachedPartial{AST: tpl.ast, Errors: tpl.Errors, Body: body}
+
+		if info != nil {
+			partial.ModTime = info.ModTime
+			partial.Size = info.Size
+		}
+
+		return partial, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{
+		name:   name,
+		body:   cached.Body,
+		engine: engine,
+		ast:    cached.AST,
+		Errors: cached.Errors,
+	}, nil
+}
@@ -1,56 +1,153 @@
 package template
 
 import (
+	"io"
 	"strings"
 
+	"github.com/jasonroelofs/late"
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/template/ast"
 	"github.com/jasonroelofs/late/template/evaluator"
 	"github.com/jasonroelofs/late/template/lexer"
 	"github.com/jasonroelofs/late/template/parser"
 )
 
+/**
+ * A Template is a compiled Late document.
+ * The body is lexed and parsed once, when the Template is built, and the
+ * resulting AST is shared by every call to Render. A Template is never modified
+ * after it has been built, so it's safe to render one Template concurrently,
+ * as long as each render gets its own context.Context.
+ */
 type Template struct {
-	body string
+	name   string
+	body   string
+	engine *late.Engine
+	ast    *ast.Template
 
-	Errors []string
+	Errors []*errors.Error
 }
 
-func New(templateBody string) *Template {
-	return &Template{
-		body: templateBody,
+// New compiles the given template body. Any parse errors are made available
+// in Template.Errors.
+func New(templateBody string, options ...func(*Template)) *Template {
+	tpl := &Template{
+		body:   templateBody,
+		engine: late.DefaultEngine(),
 	}
+
+	for _, opt := range options {
+		opt(tpl)
+	}
+
+	lexer := lexer.New(tpl.body, lexer.Delimiters(tpl.engine.Delimiters()), lexer.SilentTags(tpl.engine.SilentTag))
+	parser := parser.New(lexer, tpl.engine)
+	tpl.ast = parser.Parse()
+	tpl.ast.Name = tpl.name
+	tpl.Errors = parser.Errors
+
+	for _, err := range tpl.Errors {
+		err.Annotate(tpl.name, tpl.body)
+	}
+
+	return tpl
 }
 
-// Render the template, returning the final output as a string
-func (t *Template) Render(ctx *context.Context) string {
+// Compile is New for callers who would rather get parse errors back as an error.
+// The Template is always returned so it can be inspected even when parsing failed.
+func Compile(templateBody string, options ...func(*Template)) (*Template, error) {
+	tpl := New(templateBody, options...)
+
+	if len(tpl.Errors) > 0 {
+		return tpl, errors.List(tpl.Errors)
+	}
+
+	return tpl, nil
+}
+
+// Name sets the name this template is known by, usually its file path.
+// Errors found in this template will include this name.
+func Name(name string) func(*Template) {
+	return func(t *Template) {
+		t.name = name
+	}
+}
+
+// Engine sets the late.Engine whose filters and tags this template,
+// and any partials it includes, will be parsed and evaluated with.
+func Engine(engine *late.Engine) func(*Template) {
+	return func(t *Template) {
+		t.engine = engine
+	}
+}
+
+// Render the template, returning the final output.
+// How errors are handled depends on the context's ErrorMode. In Strict mode
+// rendering stops at the first error, which is returned. In Warn mode the template
+// is rendered as fully as possible and all errors found are returned together as
+// an errors.List. Lenient mode renders like Warn but never returns an error.
+// In all modes, every error found is recorded in ctx.Errors().
+func (t *Template) Render(ctx *context.Context) (string, error) {
+	final := strings.Builder{}
+	err := t.RenderTo(&final, ctx)
+
+	if err != nil && ctx.ErrorMode() == context.Strict {
+		return "", err
+	}
+
+	return final.String(), err
+}
+
+// RenderTo renders the template, writing output to w as it is produced
+// instead of building up the whole result in memory.
+// Errors are handled as in Render, with the exception that in Strict mode
+// any output rendered before the first error has already been written to w.
+// If writing to w fails, rendering stops and that error is returned.
+func (t *Template) RenderTo(w io.Writer, ctx *context.Context) error {
 
 	// Setup ourselves as the re-entrant render function for this context
 	// This is to ensure that the include tag (and anything else that wants to trigger
 	// a full new render stack) doesn't need to depend on template, thus causing
 	// an import cycle.
-	ctx.RenderFunc = func(body string, ctx *context.Context) string {
-		tpl := New(body)
-		// TODO Propogating errors back up the stack
-		return tpl.Render(ctx)
+	ctx.RenderFunc = func(name string, w io.Writer, ctx *context.Context) error {
+		tpl, err := loadPartial(t.engine, name, ctx)
+		if err != nil {
+			return err
+		}
+
+		// Errors are recorded in the context and reported by the top level render.
+		// A failed write is kept by the writer, which stops the including template too.
+		tpl.RenderTo(w, ctx)
+		return nil
 	}
 
-	lexer := lexer.New(t.body)
-	parser := parser.New(lexer)
-	ast := parser.Parse()
+	errorsWas := len(ctx.Errors())
 
-	if len(parser.Errors) > 0 {
-		t.Errors = parser.Errors
-		// For now, just return the original document.
-		return t.body
+	for _, err := range t.Errors {
+		ctx.AddError(err)
 	}
 
-	eval := evaluator.New(ast, ctx)
-	final := strings.Builder{}
-	results := eval.Run()
+	if !ctx.Halted() {
+		eval := evaluator.New(t.ast, ctx, t.engine)
 
-	for _, obj := range results {
-		final.WriteString(obj.Inspect())
+		if err := eval.RunTo(w); err != nil {
+			return err
+		}
 	}
 
-	return final.String()
+	errs := ctx.Errors()[errorsWas:]
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	switch ctx.ErrorMode() {
+	case context.Strict:
+		return errs[0]
+	case context.Lenient:
+		return nil
+	default:
+		return errors.List(errs)
+	}
 }
@@ -1,10 +1,22 @@
 package template
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jasonroelofs/late"
 	"github.com/jasonroelofs/late/context"
+	"github.com/jasonroelofs/late/errors"
+	"github.com/jasonroelofs/late/filter"
+	"github.com/jasonroelofs/late/object"
+	"github.com/jasonroelofs/late/tag"
+	"github.com/jasonroelofs/late/template/lexer"
 )
 
 func TestNew(t *testing.T) {
@@ -17,14 +29,139 @@ func TestNew(t *testing.T) {
 
 func TestRender(t *testing.T) {
 	tpl := New("This is a template")
-	results := tpl.Render(context.New())
-	checkNoErrors(t, tpl)
+	results, err := tpl.Render(context.New())
+	checkNoErrors(t, err)
 
 	if results != "This is a template" {
 		t.Errorf("Failed to render the template")
 	}
 }
 
+func TestCompile(t *testing.T) {
+	tpl, err := Compile("{{ 1 + 2 }}")
+
+	if err != nil {
+		t.Fatalf("Unexpected error compiling the template: %s", err)
+	}
+
+	// Compiled templates can be rendered any number of times
+	for i := 0; i < 3; i++ {
+		if results, _ := tpl.Render(context.New()); results != "3" {
+			t.Errorf("Failed to render the compiled template. Got '%s'", results)
+		}
+	}
+
+	tpl, err = Compile("{{ 1 + 2 ")
+
+	if err == nil {
+		t.Fatalf("Expected a parse error but did not get one")
+	}
+
+	if err.Error() != "(1:9) Expected CLOSE_VAR, found EOF" || len(tpl.Errors) != 1 {
+		t.Errorf("Wrong parse errors. Got '%s'", err)
+	}
+}
+
+func TestRenderConcurrently(t *testing.T) {
+	tpl, err := Compile(`{% for num in [1, 2] %}{% include "name" %}{{ num }}{% end %}`)
+	checkNoErrors(t, err)
+
+	// Partials are compiled and cached on the fly
+	reader := context.MapReader{"name": "{{ name }}"}
+
+	var wg sync.WaitGroup
+	errors := make(chan string, 50)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := context.New(context.Reader(reader))
+			ctx.Set("name", fmt.Sprintf("r%d-", i))
+
+			expected := fmt.Sprintf("r%d-1r%d-2", i, i)
+			if results, _ := tpl.Render(ctx); results != expected {
+				errors <- fmt.Sprintf("Expected '%s' got '%s'", expected, results)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errors)
+
+	for err := range errors {
+		t.Error(err)
+	}
+}
+
+// Records each write separately so tests can see output arrive in pieces
+type chunkWriter struct {
+	chunks []string
+	failAt int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.failAt > 0 && len(w.chunks) == w.failAt {
+		return 0, fmt.Errorf("write failed")
+	}
+
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+func TestRenderTo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`Hello {{ name }}!`, []string{"Hello ", "World", "!"}},
+		{`{% for x in [1, 2] %}<{{ x }}>{% end %}`, []string{"<", "1", ">", "<", "2", ">"}},
+		{`{% capture c %}{{ 1 }}{{ 2 }}{% end %}[{{ c }}]`, []string{"[", "12", "]"}},
+		{`{% assign x = 1 %}{{ "" }}{{ x }}`, []string{"1"}},
+		{`<{% include "partial" %}>`, []string{"<", "Hi ", "World", ">"}},
+	}
+
+	for i, test := range tests {
+		out := &chunkWriter{}
+		ctx := context.New(context.Reader(&TestReader{Body: "Hi {{ name }}"}))
+		ctx.Assign(context.Assigns{"name": "World"})
+
+		err := New(test.input).RenderTo(out, ctx)
+		checkNoErrors(t, err)
+
+		if strings.Join(out.chunks, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("(%d) Wrong writes. Expected %q got %q", i, test.expected, out.chunks)
+		}
+	}
+
+	// Rendering stops when the writer fails
+	out := &chunkWriter{failAt: 2}
+	err := New(`{% for x in [1, 2, 3] %}{{ x }}{% assign last = x %}{% end %}`).RenderTo(out, context.New())
+
+	if err == nil || err.Error() != "write failed" {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+
+	if len(out.chunks) != 2 {
+		t.Errorf("Kept writing after the writer failed: %q", out.chunks)
+	}
+
+	// Including a partial stops too
+	out = &chunkWriter{failAt: 2}
+	ctx := context.New(context.Reader(&TestReader{Body: "{{ 1 }}{{ 2 }}{{ 3 }}"}))
+	err = New(`{% include "partial" %}{% assign x = 1 %}`).RenderTo(out, ctx)
+
+	if err == nil || err.Error() != "write failed" {
+		t.Errorf("Expected the write error from the partial, got %v", err)
+	}
+
+	if len(out.chunks) != 2 || ctx.Get("x") != object.NULL {
+		t.Errorf("Kept going after the partial's writer failed: %q", out.chunks)
+	}
+}
+
 func TestRenderLiquidWithLiterals(t *testing.T) {
 	tests := []struct {
 		liquidInput    string
@@ -39,8 +176,8 @@ func TestRenderLiquidWithLiterals(t *testing.T) {
 
 	for _, test := range tests {
 		tpl := New(test.liquidInput)
-		results := tpl.Render(context.New())
-		checkNoErrors(t, tpl)
+		results, err := tpl.Render(context.New())
+		checkNoErrors(t, err)
 
 		if results != test.expectedOutput {
 			t.Errorf("Failed to render the template. Expected '%s' got '%s'", test.expectedOutput, results)
@@ -62,8 +199,8 @@ func TestRenderWithInitialState(t *testing.T) {
 		ctx := context.New()
 		ctx.Assign(test.assigns)
 
-		results := tpl.Render(ctx)
-		checkNoErrors(t, tpl)
+		results, err := tpl.Render(ctx)
+		checkNoErrors(t, err)
 
 		if results != test.expected {
 			t.Errorf("Failed to render. Expected '%s' got '%s'", test.expected, results)
@@ -78,8 +215,8 @@ func TestRenderWithComplexObject(t *testing.T) {
 	ctx := context.New()
 	ctx.Set("site", map[string]interface{}{"root": map[string]interface{}{"title": "Site Title"}})
 
-	results := tpl.Render(ctx)
-	checkNoErrors(t, tpl)
+	results, err := tpl.Render(ctx)
+	checkNoErrors(t, err)
 
 	if results != "Site Title" {
 		t.Errorf("Failed to render. Expected got '%s'", results)
@@ -94,18 +231,18 @@ func TestRender_RawAndComments(t *testing.T) {
 		{`Before {# Middle #} End`, "Before  End"},
 		{`{# {{ "hi" }} #}`, ""},
 
-		{`This is {{{ {{ "Raw Late Code" }} }}}`, `This is  {{ "Raw Late Code" }} `},
+		{`This is {{{ {{ "Raw Late Code" }} }}}`, `This is {{ "Raw Late Code" }}`},
 
 		// Raw and Comment should not try to parse code inside of their blocks.
 		// I would expect invalid liquid to be ignored, not erroring out.
-		{`This is {{{ Invalid {% {{ >=}}}`, `This is  Invalid {% {{ >=`},
+		{`This is {{{ Invalid {% {{ >=}}}`, `This is Invalid {% {{ >=`},
 		{`{# Don't {{ break {% ==#}`, ""},
 	}
 
 	for i, test := range tests {
 		tpl := New(test.input)
-		results := tpl.Render(context.New())
-		checkNoErrors(t, tpl)
+		results, err := tpl.Render(context.New())
+		checkNoErrors(t, err)
 
 		if results != test.expected {
 			t.Errorf("(%d) Failed to render. Expected '%s' got '%s'", i, test.expected, results)
@@ -113,6 +250,51 @@ func TestRender_RawAndComments(t *testing.T) {
 	}
 }
 
+func TestRender_Whitespace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Lines with only tags and comments are removed
+		{"A\n{% assign x = 1 %}\nB", "A\nB"},
+		{"A\n  {# note #}  \r\nB", "A\nB"},
+		{"A\n{% assign x = 1 %}{% assign y = 2 %}\n{{ x }}{{ y }}", "A\n12"},
+
+		// Blank lines and inline tags stay
+		{"A\n\n{% assign x = 1 %}\n\nB", "A\n\n\nB"},
+		{"A {% assign x = 1 %} B\n", "A  B\n"},
+		{"{% if true %}A{% end %}\n", "A\n"},
+
+		// Tags that output something keep their line
+		{"<header>\n{% include \"p\" %}\n</header>", "<header>\nP1\n</header>"},
+		{"A\n{% if true %}\n  {% include \"p\" %}\n{% else %}\n{% end %}\nB", "A\nP1\nB"},
+
+		// Content under a tag on its own line is un-indented
+		{"<ul>\n  {% for i in [1, 2] %}\n    <li>{{ i }}</li>\n  {% end %}\n</ul>", "<ul>\n  <li>1</li>\n  <li>2</li>\n</ul>"},
+		{"{% if true %}\n    A\n      B\n    C\n{% end %}", "A\n  B\nC\n"},
+		{"{% if true %}\n  {% if true %}\n    A\n  {% end %}\n  B\n{% end %}", "A\nB\n"},
+		{"{% if true %}\n\tA\n{% end %}", "A\n"},
+
+		// Trim markers
+		{"A \n {{- 1 }}", "A1"},
+		{"{{ 1 -}} \n B", "1B"},
+		{"A\n{%- if true -%}\n  B\n{%- end -%}\nC", "ABC"},
+		{"A {#- comment -#} B", "AB"},
+		{"A {{{- {{ raw }} -}}} B", "A{{ raw }}B"},
+		{"{{ 5 - 2 }} {{ -1 }}", "3 -1"},
+	}
+
+	for i, test := range tests {
+		tpl := New(test.input)
+		results, err := tpl.Render(context.New(context.Reader(context.MapReader{"p": "P1"})))
+		checkNoErrors(t, err)
+
+		if results != test.expected {
+			t.Errorf("(%d) Failed to render. Expected %q got %q", i, test.expected, results)
+		}
+	}
+}
+
 func TestRender_Tags(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -155,6 +337,17 @@ func TestRender_Tags(t *testing.T) {
 		// 9
 		{`{% for num in [1,2,3] %}{{ num }}{% end %}`, "123"},
 		{`{% assign list = [1,2,3] %}{% for num in list %}{{ num }}{% end %}`, "123"},
+		{`{% for num in (1..3) %}{{ num }}{% end %}`, "123"},
+		{`{% assign n = 2 %}{% for num in (n..n + 2) %}{{ num }}{% end %}`, "234"},
+		{`{% for num in (3..1) %}{{ num }}{% end %}`, ""},
+		{`{% for num in (1..1000000000) %}{% if num > 2 %}{% break %}{% end %}{{ num }}{% end %}`, "12"},
+		{`{% for num in (1..4) %}{{ forloop.length }}{% end %}`, "4444"},
+
+		// Hashes loop in the order their keys were added
+		{`{% for key, value in {b: 1, a: 2, c: 3} %}{{ key }}={{ value }};{% end %}`, "b=1;a=2;c=3;"},
+		{`{% for pair in {b: 1, a: 2} %}{{ pair[0] }}{{ pair[1] }}{{ pair }}{% end %}`, "b1[b,1]a2[a,2]"},
+		{`{% for key, value in {} %}{{ key }}{% end %}`, ""},
+		{`{% assign h = {a: 1} %}{% for k, v in h %}{% assign h = {a: 1, b: 2} %}{{ k }}{{ forloop.length }}{% end %}`, "a1"},
 
 		// forloop variables
 		{`{% for num in [1,2,3] %}
@@ -202,6 +395,27 @@ func TestRender_Tags(t *testing.T) {
 			"xzyzzzyzzzyzz27",
 		},
 
+		{`{% for num in (5..7) %}{{ forloop.index1 }}{{ forloop.rindex }}{{ forloop.rindex0 }},{% end %}`, "132,221,310,"},
+		{`{% for x in [1,2] %}{% for y in [3] %}{{ forloop.parentloop.index }}{{ y }}{% end %}{% end %}`, "0313"},
+		{`{% for x in [1] %}{{ forloop.parentloop }}{% end %}`, ""},
+
+		// Modifiers apply the offset, then the limit, then reverse what's left
+		{`{% for num in (1..6) limit: 2 %}{{ num }}{% end %}`, "12"},
+		{`{% for num in (1..6) offset: 4 %}{{ num }}{% end %}`, "56"},
+		{`{% for num in (1..6) reversed %}{{ num }}{% end %}`, "654321"},
+		{`{% for num in (1..6) reversed limit: 3 offset: 1 %}{{ num }}{{ forloop.index }},{% end %}`, "40,31,22,"},
+		{`{% assign n = 1 %}{% for num in [1,2,3] limit: n + 1 %}{{ num }}{{ forloop.length }}{% end %}`, "1222"},
+		{`{% for num in [1,2,3] limit: 10 offset: -1 %}{{ num }}{% end %}`, "123"},
+		{`{% for key, value in {a: 1, b: 2} reversed %}{{ key }}{{ value }}{% end %}`, "b2a1"},
+		{`{% for num in [1,2,3] offset: 3 %}{{ num }}{% end %}`, ""},
+
+		// else is for when there's nothing to loop over
+		{`{% for num in [] %}{{ num }}{% else %}Empty{% end %}`, "Empty"},
+		{`{% for num in nothing %}{{ num }}{% else %}Empty{% end %}`, "Empty"},
+		{`{% for num in [1,2] limit: 0 %}{{ num }}{% else %}Empty{% end %}`, "Empty"},
+		{`{% for num in [1,2] %}{{ num }}{% else %}Empty{% end %}`, "12"},
+		{`{% for num in [1] %}{% if num > 1 %}Big{% else %}Small{% end %}{% else %}Empty{% end %}`, "Small"},
+
 		// Interrupts
 		{`{% for num in [1,2,3] %}
 				{% if num == 1 %}{% continue %}{% end %}
@@ -229,6 +443,28 @@ func TestRender_Tags(t *testing.T) {
 			{% end %}`,
 			"12Break",
 		},
+
+		// case compares against each `when` in turn
+		{`{% case 2 %}{% when 1 %}One{% when 2 %}Two{% when 2 %}Again{% else %}Other{% end %}`, "Two"},
+		{`{% case "hat" %}{% when "shirt", "hat" %}Clothing{% when "mug" %}Kitchen{% end %}`, "Clothing"},
+		{`{% case 5 %}{% when 1, 2 %}Small{% else %}Other{% end %}`, "Other"},
+		{`{% case 5 %}{% when 1 %}One{% end %}`, ""},
+		{`{% case "1" %}{% when 1 %}Number{% when "1" %}String{% end %}`, "String"},
+		{`{% case [1, {a: 2}] %}{% when [1, {a: 2}] %}Deep{% end %}`, "Deep"},
+		{`{% assign n = 3 %}{% case n + 1 %}{% when n, n + 1 %}Four{% end %}`, "Four"},
+		{`{% case null %}{% when nothing %}Null{% end %}`, "Null"},
+		{`{% case 1 %}Ignored {{ "this" }}{% when 1 %}One{% end %}`, "One"},
+		{`{% case 1 %}
+				{% when 1 %}
+					{% case "b" %}{% when "a" %}A{% else %}Not A{% end %}
+				{% else %}
+					Other
+			{% end %}`,
+			"Not A",
+		},
+
+		// `not` applies to the whole comparison after it
+		{`{% assign list = [1, 2] %}{% if not list contains 5 %}No 5{% end %}`, "No 5"},
 	}
 
 	// TODO: Build a set of rules around whitespace management.
@@ -237,8 +473,8 @@ func TestRender_Tags(t *testing.T) {
 
 	for i, test := range tests {
 		tpl := New(test.input)
-		results := tpl.Render(context.New())
-		checkNoErrors(t, tpl)
+		results, err := tpl.Render(context.New())
+		checkNoErrors(t, err)
 
 		trimmed := replacer.Replace(results)
 
@@ -252,8 +488,8 @@ type TestReader struct {
 	Body string
 }
 
-func (t *TestReader) Read(path string) string {
-	return t.Body
+func (t *TestReader) Read(path string) (string, error) {
+	return t.Body, nil
 }
 
 func TestRender_Include(t *testing.T) {
@@ -289,16 +525,21 @@ func TestRender_Include(t *testing.T) {
 			`Hi from partial`,
 		},
 
-		// TODO error cases
+		// Block tags keep rendering in the including template after a partial
+		{
+			`{% for x in [1, 2] %}{% include "partial" %}{{ x }}{% end %}`,
+			`-`,
+			`-1-2`,
+		},
 	}
 
 	for i, test := range tests {
 		tpl := New(test.input)
 		reader := &TestReader{Body: test.partialBody}
 		ctx := context.New(context.Reader(reader))
-		results := tpl.Render(ctx)
+		results, err := tpl.Render(ctx)
 
-		checkNoErrors(t, tpl)
+		checkNoErrors(t, err)
 
 		if results != test.expected {
 			t.Errorf("(%d) Include failed. Expected '%s' got '%s'", i, test.expected, results)
@@ -306,8 +547,547 @@ func TestRender_Include(t *testing.T) {
 	}
 }
 
-func checkNoErrors(t *testing.T, tpl *Template) {
-	if len(tpl.Errors) != 0 {
-		t.Fatalf("Errors rendering the template:\n%s", strings.Join(tpl.Errors, "\n"))
+func TestRender_IncludeErrors(t *testing.T) {
+	reader := context.MapReader{"partial": "Partial"}
+
+	tests := []struct {
+		input    string
+		expected string
+		code     errors.Code
+	}{
+		{`A{% include "partial" %}B`, "APartialB", ""},
+		{`A{% include "missing" %}B`, "AB", errors.PartialNotFound},
+		{`A{% include 1 %}B`, "AB", errors.InvalidArgument},
+	}
+
+	for i, test := range tests {
+		ctx := context.New(context.Reader(reader))
+		results, err := New(test.input).Render(ctx)
+
+		if results != test.expected {
+			t.Errorf("(%d) Wrong output. Expected '%s' got '%s'", i, test.expected, results)
+		}
+
+		if test.code == "" {
+			checkNoErrors(t, err)
+			continue
+		}
+
+		if list, ok := err.(errors.List); !ok || len(list) != 1 || list[0].Code != test.code {
+			t.Errorf("(%d) Expected a %s error, got %v", i, test.code, err)
+		}
+	}
+
+	// The default reader can't read anything
+	_, err := New(`{% include "partial" %}`).Render(context.New())
+
+	if err == nil || err.Error() != "(1:4) Could not read partial 'partial': Reader not implemented. Cannot read content at partial" {
+		t.Errorf("Wrong error, got %v", err)
+	}
+}
+
+func TestRender_IncludeCachesPartials(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	reader := context.MapReader{"card": "[{{ item }}]"}
+	tpl := New(`{% for item in [1, 2, 3] %}{% include "card" %}{% end %}`, Engine(engine))
+
+	render := func(expected string) {
+		results, err := tpl.Render(context.New(context.Reader(reader)))
+		checkNoErrors(t, err)
+
+		if results != expected {
+			t.Errorf("Wrong output. Expected '%s' got '%s'", expected, results)
+		}
+	}
+
+	render("[1][2][3]")
+	render("[1][2][3]")
+
+	expected := late.CacheStats{Hits: 5, Misses: 1, Entries: 1}
+	if stats := engine.Partials().Stats(); stats != expected {
+		t.Errorf("Partial was not cached. Expected %+v got %+v", expected, stats)
+	}
+
+	// Readers that can't tell us when files change are checked by content
+	reader["card"] = "<{{ item }}>"
+	render("<1><2><3>")
+
+	if stats := engine.Partials().Stats(); stats.Misses != 2 {
+		t.Errorf("Changed partial was not recompiled, got %+v", stats)
+	}
+}
+
+func TestRender_IncludeCachesPartialsPerReader(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	tpl := New(`{% include "card" %}`, Engine(engine))
+
+	readers := []struct {
+		reader   context.MapReader
+		expected string
+	}{
+		{context.MapReader{"card": "One"}, "One"},
+		{context.MapReader{"card": "Two"}, "Two"},
+		{context.MapReader{"card": "One"}, "One"},
+	}
+
+	for round := 0; round < 2; round++ {
+		for i, test := range readers {
+			results, err := tpl.Render(context.New(context.Reader(test.reader)))
+			checkNoErrors(t, err)
+
+			if results != test.expected {
+				t.Errorf("(%d) Wrong output. Expected '%s' got '%s'", i, test.expected, results)
+			}
+		}
+	}
+
+	expected := late.CacheStats{Hits: 3, Misses: 3, Entries: 3}
+	if stats := engine.Partials().Stats(); stats != expected {
+		t.Errorf("Readers did not get their own partials. Expected %+v got %+v", expected, stats)
+	}
+}
+
+type countingReader struct {
+	*context.DirReader
+	reads int
+}
+
+func (c *countingReader) Read(path string) (string, error) {
+	c.reads++
+	return c.DirReader.Read(path)
+}
+
+func TestRender_IncludeCachesPartialsUntilTheFileChanges(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "late")
+	defer os.RemoveAll(dir)
+
+	partialPath := filepath.Join(dir, "card.late")
+	write := func(content string, when time.Time) {
+		ioutil.WriteFile(partialPath, []byte(content), 0644)
+		os.Chtimes(partialPath, when, when)
+	}
+
+	engine := late.DefaultEngine().Clone()
+	reader := &countingReader{DirReader: &context.DirReader{Root: dir, Extension: ".late"}}
+	tpl := New(`{% include "card" %}{% include "card" %}`, Engine(engine))
+
+	render := func() string {
+		results, err := tpl.Render(context.New(context.Reader(reader)))
+		checkNoErrors(t, err)
+		return results
+	}
+
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	write("one", start)
+
+	if results := render(); results != "oneone" {
+		t.Errorf("Wrong output, got '%s'", results)
+	}
+
+	// The file isn't even read again until it changes
+	render()
+
+	if reader.reads != 1 {
+		t.Errorf("Read the unchanged partial %d times", reader.reads)
+	}
+
+	write("two", start.Add(time.Second))
+
+	if results := render(); results != "twotwo" {
+		t.Errorf("Did not pick up the changed partial, got '%s'", results)
+	}
+
+	expected := late.CacheStats{Hits: 4, Misses: 2, Entries: 1}
+	if stats := engine.Partials().Stats(); stats != expected {
+		t.Errorf("Wrong cache stats. Expected %+v got %+v", expected, stats)
+	}
+
+	engine.Partials().Purge(partialPath)
+
+	if engine.Partials().Stats().Entries != 0 {
+		t.Errorf("Could not purge the partial by its path")
+	}
+}
+
+func checkNoErrors(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("Errors rendering the template:\n%s", err)
+	}
+}
+
+func TestRender_CustomEngine(t *testing.T) {
+	engine := late.NewEngine()
+	engine.AddFilter("shout", func(input object.Object, _ filter.Parameters) object.Object {
+		return object.New(input.Inspect() + "!")
+	})
+
+	tpl := New(`{{ "hi" | shout }}`, Engine(engine))
+	results, err := tpl.Render(context.New())
+	checkNoErrors(t, err)
+
+	if results != "hi!" {
+		t.Errorf("Did not render with the custom engine. Got '%s'", results)
+	}
+
+	// Tags not registered with the engine are unknown
+	tpl = New(`{% assign x = 1 %}`, Engine(engine))
+	_, err = tpl.Render(context.New())
+
+	if err == nil {
+		t.Errorf("Expected an unknown tag error from an engine without tags")
+	}
+
+	// Partials are rendered with the same engine
+	tpl = New(`{% include "partial" %}`, Engine(late.DefaultEngine().Clone()))
+	reader := &TestReader{Body: `{{ "from partial" | upcase }}`}
+	results, err = tpl.Render(context.New(context.Reader(reader)))
+	checkNoErrors(t, err)
+
+	if results != "FROM PARTIAL" {
+		t.Errorf("Did not render the partial with the template's engine. Got '%s'", results)
+	}
+}
+
+// {% greet "Ann", "Bob" in french punctuation: "!" loudly %}
+type greetTag struct{}
+
+func (g *greetTag) Parse() *tag.ParseConfig {
+	return &tag.ParseConfig{
+		TagName: "greet",
+		Rules: []tag.ParseRule{
+			tag.Named("names", tag.CommaList(tag.Expression())),
+			tag.Optional(tag.Literal("in"), tag.Named("language", tag.OneOf(tag.Literal("english"), tag.Literal("french")))),
+			tag.KeywordArgs("punctuation", "times"),
+			tag.Optional(tag.Flag("loudly")),
+		},
+	}
+}
+
+func (g *greetTag) Eval(_ *context.Context, results *tag.ParseResult) object.Object {
+	return object.New(fmt.Sprintf("%s|%s|%s|%s|%s",
+		results.Get("names").Inspect(),
+		results.Get("language").Inspect(),
+		results.Get("punctuation").Inspect(),
+		results.Nodes[2].Inspect(),
+		results.Get("loudly").Inspect(),
+	))
+}
+
+func TestRender_CustomTagRules(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	engine.AddTag(func() tag.Tag { return new(greetTag) })
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{% greet "Ann" %}`, "[Ann]|||{}|"},
+		{`{% greet "Ann", "Bob" | upcase in french %}`, "[Ann,BOB]|french||{}|"},
+		{`{% greet "Ann" loudly %}`, "[Ann]|||{}|true"},
+		{`{% greet "Ann" in english times: 1 + 1, punctuation: "!" loudly %}`, "[Ann]|english|!|{times:2,punctuation:!}|true"},
+	}
+
+	for i, test := range tests {
+		results, err := New(test.input, Engine(engine)).Render(context.New())
+		checkNoErrors(t, err)
+
+		if results != test.expected {
+			t.Errorf("(%d) Wrong results. Expected '%s' got '%s'", i, test.expected, results)
+		}
+	}
+}
+
+func TestRender_CustomTagRuleErrors(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	engine.AddTag(func() tag.Tag { return new(greetTag) })
+
+	tests := []struct {
+		input string
+		error string
+	}{
+		{`{% greet %}`, "(1:4) Error parsing tag 'greet': expected EXPRESSION"},
+		{`{% greet "Ann", %}`, "(1:15) Error parsing tag 'greet': expected EXPRESSION"},
+		{`{% greet "Ann" in %}`, "(1:16) Error parsing tag 'greet': expected `english` or `french`"},
+		{`{% greet "Ann" in german %}`, "(1:19) Error parsing nodes for tag 'greet': expected `english` or `french` found IDENT"},
+		{`{% greet "Ann" times: 1, times: 2 %}`, "(1:26) Error parsing tag 'greet': `times` given more than once"},
+		{`{% greet "Ann" times: 1, %}`, "(1:24) Error parsing tag 'greet': expected `punctuation:` or `times:`"},
+		{`{% greet "Ann" loudly loudly %}`, "(1:23) Expected CLOSE_TAG, found IDENT"},
+	}
+
+	for i, test := range tests {
+		_, err := New(test.input, Engine(engine)).Render(context.New())
+
+		if err == nil {
+			t.Fatalf("(%d) Expected an error, got none", i)
+		}
+
+		if !strings.Contains(err.Error(), test.error) {
+			t.Errorf("(%d) Wrong error. Expected '%s' got '%s'", i, test.error, err)
+		}
+	}
+}
+
+// {% while n < 3 %}...{% end %} re-evaluates its condition before every run
+type whileTag struct{}
+
+func (w *whileTag) Parse() *tag.ParseConfig {
+	return &tag.ParseConfig{
+		TagName: "while",
+		Block:   true,
+		Rules:   []tag.ParseRule{tag.LazyExpression()},
+	}
+}
+
+func (w *whileTag) Eval(ctx *context.Context, results *tag.ParseResult) object.Object {
+	condition := results.Nodes[0].(*object.Lazy)
+
+	for runs := 0; runs < 100; runs++ {
+		value := condition.Eval()
+		if object.IsError(value) {
+			return value
+		}
+
+		if !object.Truthy(value) {
+			break
+		}
+
+		ctx.EvalAll(results.Statements)
+	}
+
+	return object.NULL
+}
+
+// {% first_of a, b, c %} outputs the first truthy value, evaluating no further
+type firstOfTag struct{}
+
+func (f *firstOfTag) Parse() *tag.ParseConfig {
+	return &tag.ParseConfig{
+		TagName: "first_of",
+		Rules:   []tag.ParseRule{tag.CommaList(tag.LazyExpression())},
+	}
+}
+
+func (f *firstOfTag) Eval(_ *context.Context, results *tag.ParseResult) object.Object {
+	for _, option := range results.Nodes[0].(*object.Array).Elements {
+		value := option.(*object.Lazy).Eval()
+
+		if object.IsError(value) || object.Truthy(value) {
+			return value
+		}
+	}
+
+	return object.NULL
+}
+
+func TestRender_LazyTagRules(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	engine.AddTag(func() tag.Tag { return new(whileTag) })
+	engine.AddTag(func() tag.Tag { return new(firstOfTag) })
+
+	tests := []struct {
+		input    string
+		expected string
+		errors   []string
+	}{
+		{`{% assign n = 0 %}{% while n < 3 %}{% assign n = n + 1 %}{{ n }}{% end %}`, "123", nil},
+		{`{% while false %}Never{% end %}`, "", nil},
+		{`{% first_of false, "b" | upcase, nope %}`, "B", nil},
+		{`{% assign n = 1 %}{% first_of null, n + 1 %}`, "2", nil},
+		{`{% first_of false, nope %}`, "", []string{"(1:20) Undefined variable 'nope'"}},
+		{`{% while nope %}{% end %}`, "", []string{"(1:10) Undefined variable 'nope'"}},
+		{`{% while 1 - "a" %}{% end %}`, "", []string{"(1:12) Unknown operation: NUMBER - STRING"}},
+	}
+
+	for i, test := range tests {
+		ctx := context.New(context.Mode(context.Strict))
+		results, _ := New(test.input, Engine(engine)).Render(ctx)
+
+		if results != test.expected {
+			t.Errorf("(%d) Wrong results. Expected '%s' got '%s'", i, test.expected, results)
+		}
+
+		if len(ctx.Errors()) != len(test.errors) {
+			t.Fatalf("(%d) Wrong number of errors. Expected %d got %v", i, len(test.errors), ctx.Errors())
+		}
+
+		for j, err := range ctx.Errors() {
+			if !strings.Contains(err.Error(), test.errors[j]) {
+				t.Errorf("(%d) Wrong error. Expected '%s' got '%s'", i, test.errors[j], err)
+			}
+		}
+	}
+
+	// Malformed arguments are parse errors, not crashes
+	for i, input := range []string{`{% first_of ) %}`, `{% first_of 1, ) %}`, `{% while ] %}{% end %}`} {
+		_, err := New(input, Engine(engine)).Render(context.New())
+
+		if err == nil || !strings.Contains(err.Error(), "expected EXPRESSION found") {
+			t.Errorf("(%d) Expected a parse error for '%s', got %v", i, input, err)
+		}
+	}
+}
+
+func TestRender_Delimiters(t *testing.T) {
+	engine := late.DefaultEngine().Clone()
+	engine.SetDelimiters(lexer.DelimiterSet{OpenVar: "<<", CloseVar: ">>", OpenTag: "<%", CloseTag: "%>"})
+
+	tpl := New(`{{ x }} <% assign x = 1 %><< x >> <% include "partial" %>`, Engine(engine))
+	reader := &TestReader{Body: `<< "partial" | upcase >>`}
+	results, err := tpl.Render(context.New(context.Reader(reader)))
+	checkNoErrors(t, err)
+
+	if results != "{{ x }} 1 PARTIAL" {
+		t.Errorf("Did not render with the engine's delimiters. Got '%s'", results)
+	}
+
+	// A template's pragma wins over the engine
+	tpl = New("{# delimiters var (( )) #}\n<< 1 >>((2))", Engine(engine))
+	results, err = tpl.Render(context.New())
+	checkNoErrors(t, err)
+
+	if results != "<< 1 >>2" {
+		t.Errorf("Did not render with the pragma's delimiters. Got '%s'", results)
+	}
+
+	// Errors point into the original template
+	tpl = New("{# delimiters tag [% %] #}\nLine 2\n[% explode %]", Name("page.late"))
+
+	if len(tpl.Errors) != 1 || tpl.Errors[0].Error() != "page.late (3:4) Unknown tag 'explode'" {
+		t.Fatalf("Wrong errors. Got %v", tpl.Errors)
+	}
+
+	if tpl.Errors[0].Snippet != "[% explode %]" {
+		t.Errorf("Wrong snippet. Got `%s`", tpl.Errors[0].Snippet)
+	}
+
+	// Close delimiters must be written exactly, so they can look like the code inside them
+	tpl = New("{# delimiters var [[ ]] #}\n[[ list[0] ]]")
+	ctx := context.New()
+	ctx.Assign(context.Assigns{"list": []string{"first"}})
+	results, err = tpl.Render(ctx)
+	checkNoErrors(t, err)
+
+	if results != "first" {
+		t.Errorf("Did not render the index with [[ ]] delimiters. Got '%s'", results)
+	}
+
+	// Delimiters that can't be told apart are refused
+	tpl = New("{# delimiters var {{ }} tag {{ }} #}\n{{ 1 }}")
+
+	if len(tpl.Errors) != 1 || !strings.Contains(tpl.Errors[0].Error(), "The tag open delimiter '{{' conflicts with the var open delimiter '{{'") {
+		t.Errorf("Wrong errors. Got %v", tpl.Errors)
+	}
+}
+
+func TestRender_ErrorsKnowTheirTemplate(t *testing.T) {
+	tpl := New("Line 1\n{% explode %}", Name("page.late"))
+
+	if len(tpl.Errors) != 1 {
+		t.Fatalf("Expected one parse error, got %d", len(tpl.Errors))
+	}
+
+	err := tpl.Errors[0]
+	if err.Error() != "page.late (2:4) Unknown tag 'explode'" {
+		t.Errorf("Wrong error. Got `%s`", err)
+	}
+
+	if err.Code != errors.UnknownTag || err.Snippet != "{% explode %}" {
+		t.Errorf("Wrong error code or snippet. Got %s `%s`", err.Code, err.Snippet)
+	}
+
+	// Errors in partials point to the partial
+	tpl = New(`{% include "partial" %}{{ "done" | nope }}`, Name("page.late"))
+	reader := &TestReader{Body: "\n{{ 1 | explode }}"}
+	ctx := context.New(context.Reader(reader))
+	tpl.Render(ctx)
+
+	errs := ctx.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("Expected two runtime errors, got %d", len(errs))
+	}
+
+	if errs[0].Error() != "partial (2:8) Unknown filter 'explode'" || errs[0].Snippet != "{{ 1 | explode }}" {
+		t.Errorf("Wrong partial error. Got `%s` `%s`", errs[0], errs[0].Snippet)
+	}
+
+	if errs[1].Error() != "page.late (1:36) Unknown filter 'nope'" {
+		t.Errorf("Wrong template error. Got `%s`", errs[1])
+	}
+}
+
+func TestRender_ErrorModes(t *testing.T) {
+	tests := []struct {
+		mode     context.ErrorMode
+		input    string
+		expected string
+		errors   int
+	}{
+		// Strict stops at the first error, parse or runtime
+		{context.Strict, `Before {% explode %} After`, "", 1},
+		{context.Strict, `Before {{ "a" | nope }} {{ "b" | nope }} After`, "", 1},
+		{context.Strict, `Before {{ "a" | upcase }} After`, "Before A After", 0},
+		{context.Strict, `Before {% for x in 5 %}{{ x }}{% end %} After`, "", 1},
+		{context.Strict, `Before {{ ) }} After`, "", 1},
+
+		// Warn renders around the errors and returns them all
+		{context.Warn, `Before {% explode %} After`, "Before  After", 1},
+		{context.Warn, `Before {{ "a" | nope }} {{ "b" | nope }} After`, "Before   After", 2},
+		{context.Warn, `{{ 1 }} {{ 1 2 }} {% if %}Gone{% end %} {% assign x = 3 %}{{ x }}`, "1   3", 2},
+		{context.Warn, `Before {% for x in 5 %}{{ x }}{% end %} After`, "Before  After", 1},
+		{context.Warn, `{% if true %}{{ 1 + }}Kept{% elsif %}Gone{% else %}Not Here{% end %}`, "Kept", 2},
+		{context.Warn, `A{% case 1 %}{% when 1 | nosuch %}x{% end %}B`, "AB", 1},
+
+		// Lenient renders around the errors and stays quiet
+		{context.Lenient, `Before {% explode %} After`, "Before  After", 0},
+		{context.Lenient, `Before {{ "a" | nope }} After`, "Before  After", 0},
+	}
+
+	for i, test := range tests {
+		ctx := context.New(context.Mode(test.mode))
+		results, err := New(test.input).Render(ctx)
+
+		if results != test.expected {
+			t.Errorf("(%d) Wrong output. Expected '%s' got '%s'", i, test.expected, results)
+		}
+
+		switch {
+		case test.errors == 0 && err != nil:
+			t.Errorf("(%d) Expected no errors, got %s", i, err)
+		case test.errors == 1 && test.mode == context.Strict:
+			if _, ok := err.(*errors.Error); !ok {
+				t.Errorf("(%d) Expected a single error, got %#v", i, err)
+			}
+		case test.errors > 0 && test.mode == context.Warn:
+			if list, ok := err.(errors.List); !ok || len(list) != test.errors {
+				t.Errorf("(%d) Expected %d errors, got %#v", i, test.errors, err)
+			}
+		}
+	}
+
+	// Lenient rendering still keeps track of what went wrong
+	ctx := context.New(context.Mode(context.Lenient))
+	New(`{{ "a" | nope }}`).Render(ctx)
+
+	if len(ctx.Errors()) != 1 {
+		t.Errorf("Lenient mode did not record the error, got %d errors", len(ctx.Errors()))
+	}
+
+	// Strict mode halts evaluation right away, even inside of partials
+	reader := &TestReader{Body: `{{ "a" | nope }}{% promote x %}`}
+	ctx = context.New(context.Mode(context.Strict), context.Reader(reader))
+	New(`{% assign x = 1 %}{% include "partial" %}{% assign x = 2 %}`).Render(ctx)
+
+	if ctx.Get("x").Value() != float64(1) {
+		t.Errorf("Strict mode kept evaluating after the error, x is %v", ctx.Get("x").Value())
+	}
+
+	// Loops stop at the first error too. The loop keeps `forloop` up to date,
+	// so a copy of it shows how far the loop got.
+	ctx = context.New(context.Mode(context.Strict))
+	New(`{% for i in 1..3 %}{% assign loop = forloop %}{{ i | nope }}{% end %}`).Render(ctx)
+
+	index := ctx.Get("loop").(*object.Hash).Get(object.New("index"))
+	if index.Value() != float64(0) {
+		t.Errorf("Strict mode kept looping after the error, got to index %v", index.Value())
 	}
 }
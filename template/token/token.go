@@ -20,6 +20,9 @@ type Token struct {
 	// Number of characters in from the beginning of the current line
 	// where this token starts
 	Char int
+
+	// Byte offset into the input where this token starts
+	Offset int
 }
 
 const (
@@ -49,24 +52,32 @@ const (
 
 	TRUE  = "TRUE"
 	FALSE = "FALSE"
+	NULL  = "NULL"
 
 	DOT     = "DOT"
+	RANGE   = "RANGE"
 	COMMA   = "COMMA"
 	COLON   = "COLON"
 	ASSIGN  = "ASSIGN"
 	PERCENT = "PERCENT"
 	PIPE    = "PIPE"
 
-	PLUS   = "PLUS"
-	MINUS  = "MINUS"
-	TIMES  = "TIMES"
-	SLASH  = "SLASH"
-	LT     = "LT"
-	LT_EQ  = "LT_EQ"
-	GT     = "GT"
-	GT_EQ  = "GT_EQ"
-	EQ     = "EQ"
-	NOT_EQ = "NOT_EQ"
+	PLUS    = "PLUS"
+	MINUS   = "MINUS"
+	TIMES   = "TIMES"
+	SLASH   = "SLASH"
+	INT_DIV = "INT_DIV"
+	LT      = "LT"
+	LT_EQ   = "LT_EQ"
+	GT      = "GT"
+	GT_EQ   = "GT_EQ"
+	EQ      = "EQ"
+	NOT_EQ  = "NOT_EQ"
+
+	AND      = "AND"
+	OR       = "OR"
+	NOT      = "NOT"
+	CONTAINS = "CONTAINS"
 
 	// A special lexer token for the {% end %} token of a block.
 	END = "END"